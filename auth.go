@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+)
+
+// every scope SQUE-G asks for, see doctorFeatures for what needs which
+var authScopes = []string{
+	spotifyauth.ScopePlaylistModifyPublic,
+	spotifyauth.ScopePlaylistModifyPrivate,
+	spotifyauth.ScopePlaylistReadPrivate,
+	spotifyauth.ScopeUserFollowRead,
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func SetupAuthenticator(c *ConfigData) {
+	authOptions := []spotifyauth.AuthenticatorOption{
+		spotifyauth.WithClientID(c.User.ClientID),
+		spotifyauth.WithRedirectURL(c.User.RedirectURI),
+		spotifyauth.WithScopes(authScopes...),
+	}
+	if (c.Session.Flags & SessionFlags_PKCE) != 0 {
+		// PKCE proves the login with a per run verifier instead of the client secret
+		codeVerifier = NewCodeVerifier()
+		fmt.Println("Logging in with PKCE, the client secret will not be used.")
+	} else {
+		authOptions = append(authOptions, spotifyauth.WithClientSecret(c.User.ClientSecret))
+	}
+	auth = spotifyauth.New(authOptions...)
+}
+
+// Login returns a client for the user, from the cached token if we have one,
+// otherwise through the browser. The user ID is filled in on the way.
+func Login(c *ConfigData) *spotify.Client {
+	client := LoginFromTokenCache(c)
+	if client == nil {
+		appState = NewLoginState()
+
+		if (c.Session.Flags & SessionFlags_Headless) != 0 {
+			StartPastedURLLogin()
+		} else {
+			StartCallbackServerLogin()
+		}
+
+		// wait for auth to complete
+		client = WaitForLogin(time.Duration(c.User.LoginTimeout) * time.Second)
+	}
+
+	// use the client to make calls that require authorization
+	spotifyUser, userErr := client.CurrentUser(context.Background())
+	if userErr != nil {
+		log.Fatal(userErr)
+	}
+
+	// assign user ID
+	c.User.UserID = spotifyUser.ID
+
+	fmt.Println("You are logged in as:", spotifyUser.ID)
+
+	return client
+}
+
+// ---------------------------------------------------------
+// PKCE
+// ---------------------------------------------------------
+
+// NewCodeVerifier returns a random PKCE code verifier, 86 characters from the
+// unreserved URL alphabet as required by RFC 7636.
+func NewCodeVerifier() string {
+	buf := make([]byte, 64)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Could not generate PKCE code verifier: %s\n", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func CodeChallengeOptions(verifier string) []oauth2.AuthCodeOption {
+	if len(verifier) == 0 {
+		return nil
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func CodeVerifierOptions(verifier string) []oauth2.AuthCodeOption {
+	if len(verifier) == 0 {
+		return nil
+	}
+
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_verifier", verifier),
+	}
+}
+
+// ---------------------------------------------------------
+// Token Cache
+// ---------------------------------------------------------
+
+// CachedToken is what goes on disk, the oauth2.Token plus the granted scopes
+// which oauth2 only keeps in the raw token response.
+type CachedToken struct {
+	oauth2.Token
+	Scope string `json:"scope,omitempty"`
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func NewCachedToken(tok *oauth2.Token) *CachedToken {
+	return &CachedToken{Token: *tok, Scope: TokenScope(tok)}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (t *CachedToken) OAuthToken() *oauth2.Token {
+	tok := t.Token
+	return tok.WithExtra(map[string]interface{}{"scope": t.Scope})
+}
+
+// TokenScope returns the space separated scopes granted with the token, empty
+// if Spotify didn't say.
+func TokenScope(tok *oauth2.Token) string {
+	scope, _ := tok.Extra("scope").(string)
+	return scope
+}
+
+// cachingTokenSource wraps the token source of an authenticated client and
+// writes every newly issued token to the token cache, so a refreshed token
+// survives the run.
+type cachingTokenSource struct {
+	mu     sync.Mutex
+	config *ConfigData
+	source oauth2.TokenSource
+	last   string
+	scope  string
+}
+
+func (s *cachingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tok.AccessToken != s.last {
+		s.last = tok.AccessToken
+
+		// a refresh may leave out the scopes, they haven't changed then
+		if scope := TokenScope(tok); len(scope) > 0 {
+			s.scope = scope
+		} else {
+			tok = tok.WithExtra(map[string]interface{}{"scope": s.scope})
+		}
+
+		if saveErr := SaveCachedToken(s.config, tok); saveErr != nil {
+			fmt.Printf("Could not save token cache: %s\n", saveErr)
+		}
+	}
+
+	return tok, nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func LoadToken(path string) (*oauth2.Token, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tok := &CachedToken{}
+	if err = json.Unmarshal(data, tok); err != nil {
+		return nil, err
+	}
+
+	return tok.OAuthToken(), nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func SaveToken(path string, tok *oauth2.Token) error {
+	data, err := json.MarshalIndent(NewCachedToken(tok), "", "    ")
+	if err != nil {
+		return err
+	}
+
+	// the refresh token is as good as a password, keep it private
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// LoadCachedToken reads the token from the secrets store when there is one,
+// otherwise from the plaintext token cache.
+func LoadCachedToken(c *ConfigData) (*oauth2.Token, error) {
+	if c.Session.Secrets != nil {
+		if c.Session.Secrets.Secrets.Token == nil {
+			return nil, os.ErrNotExist
+		}
+		return c.Session.Secrets.Secrets.Token.OAuthToken(), nil
+	}
+	return LoadToken(c.User.TokenPath)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func SaveCachedToken(c *ConfigData, tok *oauth2.Token) error {
+	if c.Session.Secrets != nil {
+		c.Session.Secrets.Secrets.Token = NewCachedToken(tok)
+		return c.Session.Secrets.Save()
+	}
+	return SaveToken(c.User.TokenPath, tok)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func NewAuthenticatedClient(ctx context.Context, tok *oauth2.Token, c *ConfigData) (*spotify.Client, error) {
+	httpClient := auth.Client(ctx, tok)
+
+	if transport, ok := httpClient.Transport.(*oauth2.Transport); ok {
+		source := &cachingTokenSource{config: c, source: transport.Source, scope: TokenScope(tok)}
+		transport.Source = source
+
+		// Refresh now if the access token has expired so a rejected refresh
+		// token is found before we start scanning.
+		if _, err := source.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	return spotify.New(httpClient, spotify.WithRetry(true)), nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func LoginFromTokenCache(c *ConfigData) *spotify.Client {
+	tok, err := LoadCachedToken(c)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Could not read token cache %s: %s\n", c.User.TokenPath, err)
+		}
+		return nil
+	}
+
+	client, err := NewAuthenticatedClient(context.Background(), tok, c)
+	if err != nil {
+		// Only a token rejected by Spotify sends us back to the browser,
+		// anything else (no network, etc) would fail the login anyway.
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) || len(tok.RefreshToken) == 0 {
+			fmt.Printf("Cached token was rejected, logging in again: %s\n", err)
+			return nil
+		}
+		log.Fatal(err)
+	}
+
+	fmt.Println("Logged in with cached token.")
+
+	return client
+}
+
+// ---------------------------------------------------------
+// Interactive Login
+// ---------------------------------------------------------
+
+// callbackServer is running while we wait for a browser login, nil otherwise.
+var callbackServer *http.Server
+
+// loginResult is delivered on ch by whichever login flow is running.
+type loginResult struct {
+	client *spotify.Client
+	err    error
+}
+
+// NewLoginState returns a random OAuth state, used to match the redirect
+// back to the login we started.
+func NewLoginState() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Could not generate login state: %s\n", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// PrintLoginQRCode renders the login URL as a QR code made of block
+// characters so the login can be finished from a phone.
+func PrintLoginQRCode(url string) {
+	if (config.Session.Flags & SessionFlags_NoQRCode) != 0 {
+		return
+	}
+
+	code, err := qrcode.New(url, qrcode.Low)
+	if err != nil {
+		fmt.Printf("Could not render login QR code: %s\n", err)
+		return
+	}
+
+	fmt.Println("Or scan this QR code:")
+	fmt.Print(code.ToSmallString(false))
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func deliverLogin(result loginResult) {
+	// Only the first login counts, drop any that arrive after it
+	select {
+	case ch <- result:
+	default:
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func WaitForLogin(timeout time.Duration) *spotify.Client {
+	defer stopCallbackServer()
+
+	select {
+	case result := <-ch:
+		if result.err != nil {
+			log.Fatalf("Login failed: %s\n", result.err)
+		}
+		return result.client
+	case <-time.After(timeout):
+		log.Fatalf("Login timed out after %s. Exiting early.\n", timeout)
+	}
+	return nil
+}
+
+// CallbackAddress works out where the callback server listens and which path
+// it answers, both taken from the redirect URI so the two can't disagree.
+// The server binds to loopback unless the redirect URI names an IP address or
+// callback_listen says otherwise.
+func CallbackAddress(u *UserData) (string, string, error) {
+	redirect, err := url.Parse(u.RedirectURI)
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse redirect_uri %q: %s", u.RedirectURI, err)
+	}
+
+	if redirect.Scheme != "http" {
+		return "", "", fmt.Errorf("redirect_uri %q must be plain http for the callback server, use -headless otherwise", u.RedirectURI)
+	}
+
+	host := "127.0.0.1"
+	if len(u.CallbackListen) > 0 {
+		host = u.CallbackListen
+	} else if ip := net.ParseIP(redirect.Hostname()); ip != nil {
+		host = ip.String()
+	}
+
+	port := redirect.Port()
+	if len(port) == 0 {
+		port = "80"
+	}
+
+	path := redirect.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	return net.JoinHostPort(host, port), path, nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func StartCallbackServerLogin() {
+	addr, path, err := CallbackAddress(&config.User)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, completeAuth)
+	if path != "/" {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			log.Println("Got request for:", r.URL.String())
+			http.NotFound(w, r)
+		})
+	}
+
+	callbackServer = &http.Server{Addr: addr, Handler: mux}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Could not start callback server on %s: %s\n", addr, err)
+	}
+	fmt.Printf("Waiting for the login callback on http://%s%s\n", addr, path)
+
+	go func() {
+		err := callbackServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			deliverLogin(loginResult{err: err})
+		}
+	}()
+
+	url := auth.AuthURL(appState, CodeChallengeOptions(codeVerifier)...)
+	fmt.Println("Please log in to Spotify by visiting the following page in your browser:", url)
+	PrintLoginQRCode(url)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func stopCallbackServer() {
+	if callbackServer == nil {
+		return
+	}
+
+	// Let the handler finish writing its page before closing
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := callbackServer.Shutdown(ctx); err != nil {
+		fmt.Printf("Could not shut down callback server: %s\n", err)
+	}
+	callbackServer = nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func completeAuth(w http.ResponseWriter, r *http.Request) {
+	// A callback that isn't for our login is ignored, keep waiting for the real one
+	if st := r.FormValue("state"); st != appState {
+		http.Error(w, "State mismatch, this is not the login SQUE-G is waiting for.", http.StatusForbidden)
+		fmt.Printf("Ignoring callback with mismatched state: %s\n", st)
+		return
+	}
+
+	if (config.Session.Flags&SessionFlags_PKCE) != 0 && len(codeVerifier) == 0 {
+		http.Error(w, "Missing PKCE code verifier.", http.StatusInternalServerError)
+		deliverLogin(loginResult{err: errors.New("PKCE login requested but no code verifier was generated")})
+		return
+	}
+
+	tok, err := auth.Token(r.Context(), appState, r, CodeVerifierOptions(codeVerifier)...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Couldn't get token: %s", err), http.StatusForbidden)
+		deliverLogin(loginResult{err: err})
+		return
+	}
+
+	// use the token to get an authenticated client, caching the token for the next run
+	client, err := NewAuthenticatedClient(context.Background(), tok, &config)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Couldn't create client: %s", err), http.StatusForbidden)
+		deliverLogin(loginResult{err: err})
+		return
+	}
+
+	fmt.Fprintf(w, "Login Completed!")
+	deliverLogin(loginResult{client: client})
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func StartPastedURLLogin() {
+	url := auth.AuthURL(appState, CodeChallengeOptions(codeVerifier)...)
+	fmt.Println("Please log in to Spotify by visiting the following page in any browser:", url)
+	PrintLoginQRCode(url)
+	fmt.Println("After accepting, paste the full URL you were redirected to and press enter:")
+
+	go func() {
+		deliverLogin(completePastedURL())
+	}()
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func completePastedURL() loginResult {
+	line := ReadLine("")
+	if len(line) == 0 {
+		return loginResult{err: errors.New("no redirect URL was pasted")}
+	}
+
+	// Build the request the callback server would have received
+	r, err := http.NewRequest(http.MethodGet, line, nil)
+	if err != nil {
+		return loginResult{err: fmt.Errorf("could not parse redirect URL: %s", err)}
+	}
+
+	if st := r.URL.Query().Get("state"); st != appState {
+		return loginResult{err: fmt.Errorf("state mismatch: %s != %s", st, appState)}
+	}
+
+	tok, err := auth.Token(context.Background(), appState, r, CodeVerifierOptions(codeVerifier)...)
+	if err != nil {
+		return loginResult{err: err}
+	}
+
+	client, err := NewAuthenticatedClient(context.Background(), tok, &config)
+	if err != nil {
+		return loginResult{err: err}
+	}
+
+	return loginResult{client: client}
+}
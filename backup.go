@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_BACKUPS_DIR = "backups"                // default backups directory
+const SQUE_BACKUP_TIME_FORMAT = "20060102-150405" // backup file names, sorting by name sorts by age
+
+// ---------------------------------------------------------
+// Backup Types
+// ---------------------------------------------------------
+
+type BackupTrack struct {
+	URI     string `json:"uri"`
+	Name    string `json:"name"`
+	Artists string `json:"artists"`
+	Album   string `json:"album"`
+	AddedAt string `json:"added_at"`
+	AddedBy string `json:"added_by"`
+	IsLocal bool   `json:"is_local,omitempty"`
+}
+
+type PlaylistBackup struct {
+	CreatedAt     time.Time     `json:"created_at"`
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	Description   string        `json:"description"`
+	Owner         string        `json:"owner"`
+	Public        bool          `json:"public"`
+	Collaborative bool          `json:"collaborative"`
+	SnapshotID    string        `json:"snapshot_id"`
+	Tracks        []BackupTrack `json:"tracks"`
+}
+
+// ---------------------------------------------------------
+// Backups
+// ---------------------------------------------------------
+
+// ResolvePlaylist turns a destination key (listen_later, sets, compilation)
+// or the name of a scanned playlist into its ID. Anything else is taken as
+// an ID.
+func ResolvePlaylist(c *ConfigData, playlist string) string {
+	switch playlist {
+	case "listen_later":
+		return c.User.PlaylistListenLater
+	case "sets":
+		return c.User.PlaylistSets
+	case "compilation":
+		return c.User.PlaylistCompilation
+	}
+
+	for _, playlistMeta := range c.Playlists {
+		if playlistMeta.Name == playlist {
+			return playlistMeta.ID
+		}
+	}
+	return playlist
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func BackupPlaylist(client *spotify.Client, c *ConfigData, playlistId string) (string, error) {
+	playlist, err := client.GetPlaylist(context.Background(), spotify.ID(playlistId), spotify.Fields("id,name,description,owner(id),public,collaborative,snapshot_id"))
+	if err != nil {
+		return "", err
+	}
+
+	playlistTracks, err := GetAllPlaylistTracks(client, playlistId)
+	if err != nil {
+		return "", err
+	}
+
+	backup := PlaylistBackup{
+		CreatedAt:     time.Now(),
+		ID:            playlist.ID.String(),
+		Name:          playlist.Name,
+		Description:   playlist.Description,
+		Owner:         playlist.Owner.ID,
+		Public:        playlist.IsPublic,
+		Collaborative: playlist.Collaborative,
+		SnapshotID:    playlist.SnapshotID,
+	}
+
+	for _, playlistTrack := range playlistTracks {
+		track := playlistTrack.Track
+
+		// Keep the track that was added, not the one it is relinked to today
+		uri := string(track.URI)
+		if track.LinkedFrom != nil && len(track.LinkedFrom.URI) > 0 {
+			uri = string(track.LinkedFrom.URI)
+		}
+
+		backup.Tracks = append(backup.Tracks, BackupTrack{
+			URI:     uri,
+			Name:    track.Name,
+			Artists: CreditedArtists(track.Artists),
+			Album:   track.Album.Name,
+			AddedAt: playlistTrack.AddedAt,
+			AddedBy: playlistTrack.AddedBy.ID,
+			IsLocal: playlistTrack.IsLocal,
+		})
+	}
+
+	dir := filepath.Join(c.User.BackupsPath, backup.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(backup, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backup.CreatedAt.Format(SQUE_BACKUP_TIME_FORMAT)+".json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("  *Backed up %d tracks of %s to %s\n", len(backup.Tracks), backup.Name, path)
+	return path, nil
+}
+
+// RunBackup backs up the destination playlists, and every scanned playlist
+// too when all is set. It returns the number of playlists that failed.
+func RunBackup(client *spotify.Client, c *ConfigData, all bool) int {
+	fmt.Println("Backing up playlists....")
+
+	playlistIds := []string{c.User.PlaylistListenLater, c.User.PlaylistSets, c.User.PlaylistCompilation}
+	if all {
+		for _, playlistMeta := range c.Playlists {
+			playlistIds = append(playlistIds, playlistMeta.ID)
+		}
+	}
+
+	failed := 0
+	seen := map[string]bool{}
+	for _, playlistId := range playlistIds {
+		if len(playlistId) == 0 || seen[playlistId] {
+			continue
+		}
+		seen[playlistId] = true
+
+		if _, err := BackupPlaylist(client, c, playlistId); err != nil {
+			fmt.Printf("  !Could not back up %s: %s\n", playlistId, describeSpotifyError(err))
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func LoadPlaylistBackup(path string) (*PlaylistBackup, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &PlaylistBackup{}
+	if err := json.Unmarshal(data, backup); err != nil {
+		return nil, fmt.Errorf("Could not parse backup %s: %s", path, err)
+	}
+	return backup, nil
+}
+
+// ListBackups returns the backup files of a playlist, oldest first.
+func ListBackups(c *ConfigData, playlistId string) []string {
+	paths, _ := filepath.Glob(filepath.Join(c.User.BackupsPath, playlistId, "*.json"))
+	sort.Strings(paths)
+	return paths
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func PrintBackups(c *ConfigData) {
+	dirs, err := ioutil.ReadDir(c.User.BackupsPath)
+	if err != nil || len(dirs) == 0 {
+		fmt.Printf("No backups in %s.\n", c.User.BackupsPath)
+		return
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		for _, path := range ListBackups(c, dir.Name()) {
+			backup, err := LoadPlaylistBackup(path)
+			if err != nil {
+				fmt.Printf("  !%s\n", err)
+				continue
+			}
+			fmt.Printf("%s -- %s, %d tracks [%s]\n", strings.TrimSuffix(filepath.Base(path), ".json"), backup.Name, len(backup.Tracks), path)
+		}
+	}
+}
+
+// FindBackup picks a backup of a playlist by file path or by its version,
+// the name of the file without .json. Empty picks the latest.
+func FindBackup(c *ConfigData, playlistId string, version string) (string, error) {
+	if len(version) > 0 {
+		if _, err := os.Stat(version); err == nil {
+			return version, nil
+		}
+
+		path := filepath.Join(c.User.BackupsPath, playlistId, version+".json")
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("No backup %s of %s.", version, playlistId)
+		}
+		return path, nil
+	}
+
+	backups := ListBackups(c, playlistId)
+	if len(backups) == 0 {
+		return "", fmt.Errorf("No backups of %s in %s.", playlistId, c.User.BackupsPath)
+	}
+	return backups[len(backups)-1], nil
+}
+
+// ---------------------------------------------------------
+// Restore
+// ---------------------------------------------------------
+
+// RestorePlaylist rebuilds a playlist from a backup, replacing its tracks, or
+// creates a new playlist from it when asNew is set. The playlist is backed up
+// before it is replaced.
+func RestorePlaylist(client *spotify.Client, c *ConfigData, backup *PlaylistBackup, asNew bool) {
+	var uris []string
+	skipped := 0
+	for _, track := range backup.Tracks {
+		var spotId string
+		if track.IsLocal || !ParseRawURI(&track.URI, &spotId) {
+			skipped++
+			continue
+		}
+		uris = append(uris, track.URI)
+	}
+
+	playlistId := backup.ID
+	if asNew {
+		name := fmt.Sprintf("%s (restored %s)", backup.Name, backup.CreatedAt.Format(SQUE_DATE_FORMAT))
+		playlist, err := client.CreatePlaylistForUser(context.Background(), c.User.UserID, name, backup.Description, backup.Public, backup.Collaborative)
+		if err != nil {
+			log.Fatalf("Could not create playlist: %s\n", describeSpotifyError(err))
+		}
+		playlistId = playlist.ID.String()
+		fmt.Printf("Created playlist %s -- %s\n", playlistId, name)
+	} else {
+		if _, err := BackupPlaylist(client, c, playlistId); err != nil {
+			log.Fatalf("Could not back up %s before restoring it: %s\n", playlistId, describeSpotifyError(err))
+		}
+	}
+
+	// Replace takes at most one chunk, the rest is appended
+	firstChunk := uris
+	if len(firstChunk) > SQUE_SPOTIFY_LIMIT_PLAYLISTS {
+		firstChunk = uris[:SQUE_SPOTIFY_LIMIT_PLAYLISTS]
+	}
+
+	ids := make([]spotify.ID, len(firstChunk))
+	for i, uri := range firstChunk {
+		var spotId string
+		ParseRawURI(&uri, &spotId)
+		ids[i] = spotify.ID(spotId)
+	}
+
+	if err := client.ReplacePlaylistTracks(context.Background(), spotify.ID(playlistId), ids...); err != nil {
+		log.Fatalf("Could not replace the tracks of %s: %s\n", playlistId, describeSpotifyError(err))
+	}
+
+	added := len(firstChunk)
+	if len(uris) > len(firstChunk) {
+		added += len(AddTracksToPlaylist(client, playlistId, uris[len(firstChunk):]).Tracks)
+	}
+
+	fmt.Printf("Restored %d of %d tracks of %s.\n", added, len(backup.Tracks), backup.Name)
+	if skipped > 0 {
+		fmt.Printf("Skipped %d local or unrecognized tracks, add those by hand.\n", skipped)
+	}
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_CONFIG_ENV = "SQUEG_CONFIG" // default user.data path when none is given
+const SQUE_CONFIG_DEFAULT = "user.data"
+
+// ---------------------------------------------------------
+// Command Types
+// ---------------------------------------------------------
+
+type command struct {
+	Name    string
+	Args    string
+	Summary string
+	Run     func(name string, args []string)
+}
+
+// loginOptions are the flags every command that logs in accepts.
+type loginOptions struct {
+	PKCE     bool
+	Headless bool
+	NoQRCode bool
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func commandList() []command {
+	return []command{
+		{"scan", "[-a] [-p] [-d date] [-i] [-n]", "scan followed artists and/or playlists and queue new tracks", runScanCommand},
+		{"plan", "[-a] [-p] [-d date] [-i] [-plan file]", "scan and save what would be added and saved to a plan file", runPlanCommand},
+		{"apply", "[-plan file]", "add and save exactly what a plan file holds", runApplyCommand},
+		{"undo", "[-y]", "remove the tracks the last run added and restore its dates", runUndoCommand},
+		{"backup", "[-all]", "back up the destination playlists to local files", runBackupCommand},
+		{"restore", "[-list] [-playlist key] [-backup version] [-new] [-y]", "rebuild a playlist from a backup", runRestoreCommand},
+		{"playlists", "", "print the playlists you follow", runPlaylistsCommand},
+		{"history", "", "print the last run dates and when every scanned playlist last changed", runHistoryCommand},
+		{"config init", "", "interactively create user.data and its last run file", runConfigInitCommand},
+		{"config validate", "", "check user.data and its last run file offline", runConfigValidateCommand},
+		{"config doctor", "[-a] [-p] [-fp]", "log in and check user.data against Spotify", runConfigDoctorCommand},
+		{"config secrets", "", "create or rotate the encrypted secrets store", runConfigSecretsCommand},
+		{"config schema", "", "print the JSON Schema of user.data", runConfigSchemaCommand},
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func PrintUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "Usage: squeg <command> [flags] [user.data]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, cmd := range commandList() {
+		fmt.Fprintf(out, "  %-18s %s\n", cmd.Name, cmd.Summary)
+		if len(cmd.Args) > 0 {
+			fmt.Fprintf(out, "  %-18s   %s %s\n", "", cmd.Name, cmd.Args)
+		}
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "user.data defaults to $%s, then ./%s.\n", SQUE_CONFIG_ENV, SQUE_CONFIG_DEFAULT)
+	fmt.Fprintln(out, "Run 'squeg <command> -h' for the flags of a command.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "The old form 'squeg <user.data> [-a] [-p] [-d date] [-fp]' still works.")
+}
+
+// RunCommandLine runs the command named by args, translating the old
+// '<user.data> [options]' form first.
+func RunCommandLine(args []string) {
+	if len(args) == 0 {
+		PrintUsage()
+		os.Exit(2)
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		PrintUsage()
+		return
+	}
+
+	if !isCommand(args[0]) {
+		legacy, err := translateLegacyArgs(args)
+		if err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "%s\n\n", err)
+			PrintUsage()
+			os.Exit(2)
+		}
+		args = legacy
+	}
+
+	for _, cmd := range commandList() {
+		words := strings.Fields(cmd.Name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.Name {
+			cmd.Run(cmd.Name, args[len(words):])
+			return
+		}
+	}
+
+	fmt.Fprintf(flag.CommandLine.Output(), "Unknown command: %s\n\n", strings.Join(args, " "))
+	PrintUsage()
+	os.Exit(2)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func isCommand(word string) bool {
+	for _, cmd := range commandList() {
+		if strings.Fields(cmd.Name)[0] == word {
+			return true
+		}
+	}
+	return false
+}
+
+// translateLegacyArgs turns '<user.data> [options]' into the matching
+// command. Options that end the run early win over scanning, in the order
+// the old options were checked.
+func translateLegacyArgs(args []string) ([]string, error) {
+	path := args[0]
+	if strings.HasPrefix(path, "-") {
+		if path == "-schema" {
+			return []string{"config", "schema"}, nil
+		}
+		return nil, fmt.Errorf("Expected a command or the path to user.data, found %s.", path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%s is neither a command nor a user.data file.", path)
+	}
+
+	var loginFlags, scanFlags, doctorFlags []string
+	name := "scan"
+	priority := map[string]int{"scan": 0, "playlists": 1, "config doctor": 2, "config secrets": 3, "config validate": 4, "config init": 5, "config schema": 6}
+	use := func(cmd string) {
+		if priority[cmd] > priority[name] {
+			name = cmd
+		}
+	}
+
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-dry-run":
+			scanFlags = append(scanFlags, args[i])
+		case "-a", "-p":
+			scanFlags = append(scanFlags, args[i])
+			doctorFlags = append(doctorFlags, args[i])
+		case "-d":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("-d needs a date, year-month-day.")
+			}
+			scanFlags = append(scanFlags, "-d", args[i+1])
+			i++
+		case "-pkce", "-headless", "-noqr":
+			loginFlags = append(loginFlags, args[i])
+		case "-fp":
+			doctorFlags = append(doctorFlags, args[i])
+			use("playlists")
+		case "-doctor":
+			use("config doctor")
+		case "-secrets":
+			use("config secrets")
+		case "-validate":
+			use("config validate")
+		case "-init":
+			use("config init")
+		case "-schema":
+			use("config schema")
+		default:
+			return nil, fmt.Errorf("Unknown option %s.", args[i])
+		}
+	}
+
+	result := strings.Fields(name)
+	switch name {
+	case "scan":
+		result = append(append(result, scanFlags...), loginFlags...)
+	case "playlists", "config init":
+		result = append(result, loginFlags...)
+	case "config doctor":
+		result = append(append(result, doctorFlags...), loginFlags...)
+	}
+	if name != "config schema" {
+		result = append(result, path)
+	}
+
+	return result, nil
+}
+
+// ---------------------------------------------------------
+// Flags
+// ---------------------------------------------------------
+
+func newFlagSet(name string, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: squeg %s [flags] [user.data]\n\n%s.\n\nFlags:\n", name, strings.ToUpper(summary[:1])+summary[1:])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func addConfigFlag(fs *flag.FlagSet) *string {
+	def := os.Getenv(SQUE_CONFIG_ENV)
+	if len(def) == 0 {
+		def = SQUE_CONFIG_DEFAULT
+	}
+	return fs.String("config", def, "path to user.data, may also be given as the last argument")
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func addLoginFlags(fs *flag.FlagSet) *loginOptions {
+	options := &loginOptions{}
+	fs.BoolVar(&options.PKCE, "pkce", false, "log in with Authorization Code + PKCE, only the client ID is needed")
+	fs.BoolVar(&options.Headless, "headless", false, "log in by pasting the redirect URL instead of running a callback server")
+	fs.BoolVar(&options.NoQRCode, "noqr", false, "don't print the login page as a QR code")
+	return options
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (o *loginOptions) apply(c *ConfigData) {
+	if o.PKCE {
+		c.Session.Flags |= SessionFlags_PKCE
+	}
+	if o.Headless {
+		c.Session.Flags |= SessionFlags_Headless
+	}
+	if o.NoQRCode {
+		c.Session.Flags |= SessionFlags_NoQRCode
+	}
+}
+
+// scanOptions are the flags of the commands that scan.
+type scanOptions struct {
+	Artists   bool
+	Playlists bool
+	Date      string
+	Review    bool
+	dateTime  time.Time
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func addScanFlags(fs *flag.FlagSet) *scanOptions {
+	options := &scanOptions{}
+	fs.BoolVar(&options.Artists, "a", false, "scan followed artists")
+	fs.BoolVar(&options.Artists, "artists", false, "same as -a")
+	fs.BoolVar(&options.Playlists, "p", false, "scan the playlists in user.data")
+	fs.BoolVar(&options.Playlists, "playlists", false, "same as -p")
+	fs.StringVar(&options.Date, "d", "", "scan from `year-month-day` instead of the last run date of the scanned categories")
+	fs.StringVar(&options.Date, "date", "", "same as -d")
+	fs.BoolVar(&options.Review, "i", false, "review the queue in the terminal before adding, to accept, reject or re-route tracks")
+	fs.BoolVar(&options.Review, "review", false, "same as -i")
+	return options
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (o *scanOptions) check(fs *flag.FlagSet) {
+	if !o.Artists && !o.Playlists {
+		usageError(fs, "Nothing to scan, pass -a and/or -p.")
+	}
+
+	if len(o.Date) > 0 {
+		var err error
+		if o.dateTime, err = time.Parse(SQUE_DATE_FORMAT, o.Date); err != nil {
+			usageError(fs, "Could not parse date %q, expected year-month-day.", o.Date)
+		}
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (o *scanOptions) apply(c *ConfigData) {
+	if o.Artists {
+		c.Session.Flags |= SessionFlags_ScanArtists
+	}
+	if o.Playlists {
+		c.Session.Flags |= SessionFlags_ScanPlaylists
+	}
+	if len(o.Date) > 0 {
+		OverrideLastRunDate(c, o.dateTime)
+	}
+	if o.Review {
+		c.Session.Flags |= SessionFlags_Review
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func addPlanFlag(fs *flag.FlagSet) *string {
+	return fs.String("plan", "", "path to the plan file, "+SQUE_PLAN_FILE+" next to user.data by default")
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func defaultPlanPath(planPath string, userDataPath string) string {
+	if len(planPath) > 0 {
+		return planPath
+	}
+	return defaultPath(userDataPath, SQUE_PLAN_FILE)
+}
+
+// parseFlags parses flags wherever they are among the arguments, so both
+// 'scan -a user.data' and 'scan user.data -a' work, and returns user.data.
+func parseFlags(fs *flag.FlagSet, args []string, configPath *string) string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(positional) > 1 {
+		fmt.Fprintf(fs.Output(), "Expected only the path to user.data, found: %s\n\n", strings.Join(positional, " "))
+		fs.Usage()
+		os.Exit(2)
+	}
+	if len(positional) == 1 {
+		return positional[0]
+	}
+	return *configPath
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) {
+	fmt.Fprintf(fs.Output(), format+"\n\n", args...)
+	fs.Usage()
+	os.Exit(2)
+}
+
+// ---------------------------------------------------------
+// Commands
+// ---------------------------------------------------------
+
+func runScanCommand(name string, args []string) {
+	fs := newFlagSet(name, "scan followed artists and/or playlists and queue new tracks")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	scan := addScanFlags(fs)
+	var dryRun bool
+	fs.BoolVar(&dryRun, "n", false, "dry run, report which tracks would go to which playlist and which dates would be saved without adding or saving anything")
+	fs.BoolVar(&dryRun, "dry-run", false, "same as -n")
+	path := parseFlags(fs, args, configPath)
+
+	// Setup last run and playlist meta data
+	scan.check(fs)
+	InitConfigData(&config, path)
+	login.apply(&config)
+	scan.apply(&config)
+	if dryRun {
+		config.Session.Flags |= SessionFlags_DryRun
+	}
+
+	// ClientID, SecretID
+	SetupAuthenticator(&config)
+
+	RunScan(Login(&config))
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runPlanCommand(name string, args []string) {
+	fs := newFlagSet(name, "scan like scan does, but save what would be added and saved to a plan file for apply instead")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	scan := addScanFlags(fs)
+	planPath := addPlanFlag(fs)
+	path := parseFlags(fs, args, configPath)
+
+	scan.check(fs)
+	InitConfigData(&config, path)
+	login.apply(&config)
+	scan.apply(&config)
+
+	SetupAuthenticator(&config)
+	client := Login(&config)
+
+	ScanTracks(client)
+	plan := BuildPlan(&config, &cache, &adder)
+	SkipTracksInDestinations(client, plan)
+
+	fmt.Println("----------------------------------------------")
+	PrintPlan(&config, plan)
+	fmt.Println("----------------------------------------------")
+
+	*planPath = defaultPlanPath(*planPath, path)
+	if err := SavePlan(*planPath, plan); err != nil {
+		log.Fatalf("Could not save plan: %s\n", err)
+	}
+	fmt.Printf("Saved plan to %s, nothing was added or saved. Run 'squeg apply' to carry it out.\n", *planPath)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runApplyCommand(name string, args []string) {
+	fs := newFlagSet(name, "add and save exactly what a plan file holds, unless it is stale or was already applied")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	planPath := addPlanFlag(fs)
+	path := parseFlags(fs, args, configPath)
+
+	InitConfigData(&config, path)
+	login.apply(&config)
+
+	*planPath = defaultPlanPath(*planPath, path)
+	plan, err := LoadPlan(*planPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := CheckPlan(&config, plan); err != nil {
+		log.Fatalf("Refusing to apply %s: %s\n", *planPath, err)
+	}
+
+	SetupAuthenticator(&config)
+	client := Login(&config)
+
+	// Mark the plan first, a failed apply must not be repeated on top of the tracks it added
+	appliedAt := time.Now()
+	plan.AppliedAt = &appliedAt
+	if err := SavePlan(*planPath, plan); err != nil {
+		log.Fatalf("Could not mark the plan as applied: %s\n", err)
+	}
+
+	fmt.Printf("Applying plan made at %s.\n", plan.CreatedAt.Format(time.RFC1123))
+	ApplyPlan(client, &config, plan)
+
+	fmt.Println("----------------------------------------------")
+	fmt.Println("Done!")
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runUndoCommand(name string, args []string) {
+	fs := newFlagSet(name, "remove exactly the tracks the last run added and restore the last run dates it overwrote")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	var confirmed bool
+	fs.BoolVar(&confirmed, "y", false, "don't ask before undoing")
+	path := parseFlags(fs, args, configPath)
+
+	InitConfigData(&config, path)
+	login.apply(&config)
+
+	// Don't log in for nothing
+	if LastUndoableRun(LoadRunRecords(config.User.RunsPath)) < 0 {
+		fmt.Println("Nothing to undo.")
+		return
+	}
+
+	SetupAuthenticator(&config)
+	UndoLastRun(Login(&config), &config, confirmed)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runBackupCommand(name string, args []string) {
+	fs := newFlagSet(name, "back up the track list and details of the destination playlists to local versioned files")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	var all bool
+	fs.BoolVar(&all, "all", false, "back up every scanned playlist too")
+	path := parseFlags(fs, args, configPath)
+
+	InitConfigData(&config, path)
+	login.apply(&config)
+
+	SetupAuthenticator(&config)
+	if RunBackup(Login(&config), &config, all) > 0 {
+		os.Exit(1)
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runRestoreCommand(name string, args []string) {
+	fs := newFlagSet(name, "rebuild a playlist from a backup")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	var list, asNew, confirmed bool
+	var playlist, version string
+	fs.BoolVar(&list, "list", false, "list the backups and exit")
+	fs.StringVar(&playlist, "playlist", "listen_later", "playlist to restore: listen_later, sets, compilation, the name of a scanned playlist or an ID")
+	fs.StringVar(&version, "backup", "", "backup to restore, by version or path, the latest by default")
+	fs.BoolVar(&asNew, "new", false, "create a new playlist from the backup instead of replacing the tracks of the existing one")
+	fs.BoolVar(&confirmed, "y", false, "don't ask before restoring")
+	path := parseFlags(fs, args, configPath)
+
+	InitConfigData(&config, path)
+	login.apply(&config)
+
+	if list {
+		PrintBackups(&config)
+		return
+	}
+
+	playlistId := ResolvePlaylist(&config, playlist)
+	if len(playlistId) == 0 {
+		usageError(fs, "No %s playlist is set.", playlist)
+	}
+
+	backupPath, err := FindBackup(&config, playlistId, version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	backup, err := LoadPlaylistBackup(backupPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Backup %s of %s, %d tracks from %s.\n", backupPath, backup.Name, len(backup.Tracks), backup.CreatedAt.Format(time.RFC1123))
+	question := fmt.Sprintf("Replace the tracks of %s with it?", backup.Name)
+	if asNew {
+		question = "Create a new playlist from it?"
+	}
+	if !confirmed && !promptYesNo(question, false) {
+		fmt.Println("Nothing was restored.")
+		return
+	}
+
+	SetupAuthenticator(&config)
+	RestorePlaylist(Login(&config), &config, backup, asNew)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runPlaylistsCommand(name string, args []string) {
+	fs := newFlagSet(name, "print the playlists you follow")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	path := parseFlags(fs, args, configPath)
+
+	InitConfigData(&config, path)
+	login.apply(&config)
+	config.Session.Flags |= SessionFlags_PrintFollowedPlaylists
+
+	SetupAuthenticator(&config)
+	client := Login(&config)
+
+	fmt.Println("----------------------------------------------")
+	fmt.Println("Displaying followed playlists.")
+	fmt.Println("----------------------------------------------")
+	ShowFollowedPlaylists(client, &config)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runHistoryCommand(name string, args []string) {
+	fs := newFlagSet(name, "print the last run dates and when every scanned playlist last changed")
+	configPath := addConfigFlag(fs)
+	path := parseFlags(fs, args, configPath)
+
+	// InitConfigData prints the last run dates
+	InitConfigData(&config, path)
+
+	if len(config.User.PlaylistMetaPath) == 0 {
+		return
+	}
+
+	f, err := os.Open(config.User.PlaylistMetaPath)
+	if err != nil {
+		fmt.Printf("No playlist updates saved yet: %s\n", err)
+		return
+	}
+	defer f.Close()
+
+	names := map[string]string{}
+	for _, playlistMeta := range config.Playlists {
+		names[playlistMeta.ID] = playlistMeta.Name
+	}
+
+	fmt.Println("----------------------------------------------")
+	fmt.Println("Playlists last updated:")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		idAndDate := strings.Split(scanner.Text(), ",")
+		if len(idAndDate) != 2 {
+			continue
+		}
+		date, dateErr := time.Parse(time.UnixDate, idAndDate[1])
+		if dateErr != nil {
+			continue
+		}
+		fmt.Printf("[%s] %s -- %s\n", date.Format(SQUE_DATE_FORMAT), idAndDate[0], names[idAndDate[0]])
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runConfigInitCommand(name string, args []string) {
+	fs := newFlagSet(name, "interactively create user.data and its last run file")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	path := parseFlags(fs, args, configPath)
+
+	login.apply(&config)
+	RunInitWizard(path)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runConfigValidateCommand(name string, args []string) {
+	fs := newFlagSet(name, "check user.data and its last run file offline, reporting every problem with its line")
+	configPath := addConfigFlag(fs)
+	path := parseFlags(fs, args, configPath)
+
+	// Validate the raw file, InitConfigData would stop at the first problem
+	problems := ValidateConfigFile(path)
+	PrintConfigProblems(path, problems)
+	if CountConfigErrors(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runConfigDoctorCommand(name string, args []string) {
+	fs := newFlagSet(name, "log in and check every configured playlist exists, the destinations can be added to and the granted scopes cover the checked features")
+	configPath := addConfigFlag(fs)
+	login := addLoginFlags(fs)
+	var scanArtists, scanPlaylists, printPlaylists bool
+	fs.BoolVar(&scanArtists, "a", false, "check the scopes for scanning artists")
+	fs.BoolVar(&scanPlaylists, "p", false, "check the scopes for scanning playlists")
+	fs.BoolVar(&printPlaylists, "fp", false, "check the scopes for printing followed playlists")
+	path := parseFlags(fs, args, configPath)
+
+	InitConfigData(&config, path)
+	login.apply(&config)
+	if scanArtists {
+		config.Session.Flags |= SessionFlags_ScanArtists
+	}
+	if scanPlaylists {
+		config.Session.Flags |= SessionFlags_ScanPlaylists
+	}
+	if printPlaylists {
+		config.Session.Flags |= SessionFlags_PrintFollowedPlaylists
+	}
+
+	SetupAuthenticator(&config)
+	client := Login(&config)
+
+	if RunDoctor(client, &config) > 0 {
+		os.Exit(1)
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runConfigSecretsCommand(name string, args []string) {
+	fs := newFlagSet(name, "create or rotate the encrypted secrets store")
+	configPath := addConfigFlag(fs)
+	path := parseFlags(fs, args, configPath)
+
+	InitConfigData(&config, path)
+	RotateSecrets(&config)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func runConfigSchemaCommand(name string, args []string) {
+	fs := newFlagSet(name, "print the JSON Schema of user.data")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	PrintConfigSchema()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_ENV_PREFIX = "SQUEG_" // environment overrides are SQUEG_ + the upper case json key
+
+// ---------------------------------------------------------
+// Config Formats
+// ---------------------------------------------------------
+
+type ConfigFormat int
+
+const (
+	ConfigFormat_JSON ConfigFormat = iota
+	ConfigFormat_YAML
+	ConfigFormat_TOML
+)
+
+func (f ConfigFormat) String() string {
+	switch f {
+	case ConfigFormat_YAML:
+		return "YAML"
+	case ConfigFormat_TOML:
+		return "TOML"
+	}
+	return "JSON"
+}
+
+// a TOML table header or key = value line, neither of which is valid YAML
+var tomlLinePattern = regexp.MustCompile(`^\s*(\[\[?[A-Za-z0-9_."]+\]\]?|[A-Za-z0-9_"-]+\s*=)`)
+
+// DetectConfigFormat picks the format from the file extension, falling back
+// to sniffing the content for files like user.data. JSON is the default.
+func DetectConfigFormat(path string, data []byte) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ConfigFormat_JSON
+	case ".yaml", ".yml":
+		return ConfigFormat_YAML
+	case ".toml":
+		return ConfigFormat_TOML
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			return ConfigFormat_JSON
+		}
+		if tomlLinePattern.MatchString(line) {
+			return ConfigFormat_TOML
+		}
+		if strings.Contains(line, ":") || strings.HasPrefix(line, "---") {
+			return ConfigFormat_YAML
+		}
+		break
+	}
+
+	return ConfigFormat_JSON
+}
+
+// ConfigToJSON converts a YAML or TOML config to JSON so every format is
+// decoded by the same json.Unmarshal against ConfigData, and has the same
+// schema.
+func ConfigToJSON(data []byte, format ConfigFormat) ([]byte, error) {
+	var generic interface{}
+
+	switch format {
+	case ConfigFormat_YAML:
+		if err := yaml.Unmarshal(data, &generic); err != nil {
+			return nil, err
+		}
+	case ConfigFormat_TOML:
+		table := map[string]interface{}{}
+		if _, err := toml.NewDecoder(bytes.NewReader(data)).Decode(&table); err != nil {
+			return nil, err
+		}
+		generic = table
+	default:
+		return data, nil
+	}
+
+	return json.Marshal(generic)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func UnmarshalConfig(path string, data []byte, c *ConfigData) error {
+	format := DetectConfigFormat(path, data)
+
+	jsonBytes, err := ConfigToJSON(data, format)
+	if err != nil {
+		return fmt.Errorf("could not parse %s as %s: %s", path, format, err)
+	}
+
+	return json.Unmarshal(jsonBytes, c)
+}
+
+// plainNumbers turns the json.Numbers of a generic config back into int64 or
+// float64, so YAML and TOML write -1 rather than "-1" or -1.0.
+func plainNumbers(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = plainNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = plainNumbers(item)
+		}
+	case json.Number:
+		if n, err := typed.Int64(); err == nil {
+			return n
+		}
+		f, _ := typed.Float64()
+		return f
+	}
+	return value
+}
+
+// WriteConfigFile writes the config in the format its extension asks for,
+// JSON when the extension says nothing.
+func WriteConfigFile(path string, c *ConfigData) error {
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	format := DetectConfigFormat(path, nil)
+	if format != ConfigFormat_JSON {
+		var generic interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err = dec.Decode(&generic); err != nil {
+			return err
+		}
+		generic = plainNumbers(generic)
+
+		if format == ConfigFormat_YAML {
+			data, err = yaml.Marshal(generic)
+		} else {
+			var buf bytes.Buffer
+			err = toml.NewEncoder(&buf).Encode(generic)
+			data = buf.Bytes()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// ---------------------------------------------------------
+// Environment Overrides
+// ---------------------------------------------------------
+
+func userDataEnvName(jsonKey string) string {
+	return SQUE_ENV_PREFIX + strings.ToUpper(jsonKey)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func jsonKey(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	return strings.Split(tag, ",")[0]
+}
+
+// ApplyEnvOverrides overwrites every UserData field that has a json key with
+// the matching SQUEG_* environment variable, so SQUEG_CLIENT_ID replaces
+// client_id. Lists are comma separated. Unset variables leave the file value
+// alone, set but empty variables clear it. Variables that can't be parsed
+// leave it alone too and are returned as errors, all of them.
+func ApplyEnvOverrides(u *UserData) []error {
+	var errs []error
+	value := reflect.ValueOf(u).Elem()
+	userType := value.Type()
+
+	for i := 0; i < userType.NumField(); i++ {
+		key := jsonKey(userType.Field(i))
+		if len(key) == 0 {
+			continue
+		}
+
+		name := userDataEnvName(key)
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(env)
+		case reflect.Int:
+			n, err := strconv.Atoi(env)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Could not parse %s: %s", name, err))
+				continue
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(env)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Could not parse %s: %s", name, err))
+				continue
+			}
+			field.SetBool(b)
+		case reflect.Slice:
+			var values []string
+			for _, v := range strings.Split(env, ",") {
+				if v = strings.TrimSpace(v); len(v) > 0 {
+					values = append(values, v)
+				}
+			}
+			field.Set(reflect.ValueOf(values))
+		default:
+			errs = append(errs, fmt.Errorf("%s can't be set from the environment", name))
+			continue
+		}
+
+		fmt.Printf("Using %s from the environment.\n", name)
+	}
+
+	return errs
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func envOverrides(jsonKey string) bool {
+	_, ok := os.LookupEnv(userDataEnvName(jsonKey))
+	return ok
+}
@@ -0,0 +1,190 @@
+package main
+
+import "fmt"
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_DUPLICATE_LENGTH_SLACK = 3000 // in milliseconds, releases of one recording differ by a few seconds at most
+
+// ---------------------------------------------------------
+// Duplicates
+// ---------------------------------------------------------
+
+// recordingKey is the normalized main artist, song and variant of a track.
+// Tracks sharing it are the same song, and the same recording too when
+// their length matches or they are different versions.
+func recordingKey(trackData Track) string {
+	mainArtist := ""
+	if len(trackData.Artists) > 0 {
+		mainArtist = trackData.Artists[0].Name
+	}
+	return normalizeName(mainArtist) + " --- " + trackData.Title.Canonical + " --- " + trackData.Title.Variant
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func sameLength(a Track, b Track) bool {
+	diff := a.Duration - b.Duration
+	return diff <= SQUE_DUPLICATE_LENGTH_SLACK && diff >= -SQUE_DUPLICATE_LENGTH_SLACK
+}
+
+// duplicateGroup is every queued copy of one recording.
+type duplicateGroup struct {
+	Tracks  []int
+	Reasons map[int]string // why each track after the first joined
+	Keep    int
+}
+
+// matches tells whether a track with the group's recording key is another
+// copy, and why. Edits and extended versions run shorter or longer, so the
+// length is only compared between tracks of one version.
+func (g *duplicateGroup) matches(cache *Cache, trackData Track) (string, bool) {
+	lengthTags := VersionTags_Edit | VersionTags_Extended
+
+	reason := "other version of the same song"
+	for _, member := range g.Tracks {
+		memberData := cache.TrackDatas[member]
+		if memberData.Title.Versions&lengthTags != trackData.Title.Versions&lengthTags {
+			continue
+		}
+		if !sameLength(memberData, trackData) {
+			return "", false
+		}
+		reason = "same artist, title and length"
+	}
+	return reason, true
+}
+
+// cullReason is why a culled track is a copy of the one kept. The kept
+// copy may have replaced the one a track joined by, so the reason is told
+// against it where it can be, else it is why the track joined.
+func (g *duplicateGroup) cullReason(cache *Cache, trackDataIndex int) string {
+	trackData, keepData := cache.TrackDatas[trackDataIndex], cache.TrackDatas[g.Keep]
+	if len(trackData.ISRC) > 0 && trackData.ISRC == keepData.ISRC {
+		return "same ISRC " + trackData.ISRC
+	}
+
+	if recordingKey(trackData) == recordingKey(keepData) {
+		lengthTags := VersionTags_Edit | VersionTags_Extended
+		if trackData.Title.Versions&lengthTags != keepData.Title.Versions&lengthTags {
+			return "other version of the same song"
+		}
+		if sameLength(trackData, keepData) {
+			return "same artist, title and length"
+		}
+	}
+
+	if reason, ok := g.Reasons[trackDataIndex]; ok {
+		return reason
+	}
+	return g.Reasons[g.Keep]
+}
+
+// preferCopy tells whether a copy of a recording beats the one kept so far,
+// by version preference and then the album over a single.
+func preferCopy(c *ConfigData, cache *Cache, trackDataIndex int, keep int) bool {
+	trackData, keepData := cache.TrackDatas[trackDataIndex], cache.TrackDatas[keep]
+
+	rank, keepRank := VersionRank(c.User.VersionPreference, trackData.Title), VersionRank(c.User.VersionPreference, keepData.Title)
+	if rank != keepRank {
+		return rank < keepRank
+	}
+
+	if trackData.Album < 0 || keepData.Album < 0 {
+		return false
+	}
+	return cache.AlbumDatas[trackData.Album].Type == AlbumType_Album && cache.AlbumDatas[keepData.Album].Type == AlbumType_Single
+}
+
+// CullDuplicateTracks keeps one copy of every recording in the queue.
+// Releases of a recording under other track IDs, like a single and its
+// album or a radio edit, match by ISRC or else by main artist, song and
+// length. The copy kept is the one whose version comes first in the
+// version preference, then the album version over a single, then the
+// first queued. Culled tracks are marked IsDuplicate and logged.
+func CullDuplicateTracks(c *ConfigData, cache *Cache, adder *TrackAdder) {
+	var groups []*duplicateGroup
+	byISRC := map[string]*duplicateGroup{}
+	byRecording := map[string][]*duplicateGroup{}
+	groupOf := map[int]*duplicateGroup{}
+
+	lists := []*[]int{&adder.ListenLater, &adder.Sets, &adder.Compilations}
+
+	// Group every queued track with its copies
+	for _, tracks := range lists {
+		for _, trackDataIndex := range *tracks {
+			// Tracks found by both scans are queued twice
+			if _, ok := groupOf[trackDataIndex]; ok {
+				continue
+			}
+			trackData := cache.TrackDatas[trackDataIndex]
+			key := recordingKey(trackData)
+
+			var group *duplicateGroup
+			reason := ""
+			if found, ok := byISRC[trackData.ISRC]; ok && len(trackData.ISRC) > 0 {
+				group, reason = found, "same ISRC "+trackData.ISRC
+			} else {
+				for _, found := range byRecording[key] {
+					if why, ok := found.matches(cache, trackData); ok {
+						group, reason = found, why
+						break
+					}
+				}
+			}
+
+			if group == nil {
+				group = &duplicateGroup{Keep: trackDataIndex, Reasons: map[int]string{}}
+				groups = append(groups, group)
+				byRecording[key] = append(byRecording[key], group)
+			} else {
+				group.Reasons[trackDataIndex] = reason
+				if preferCopy(c, cache, trackDataIndex, group.Keep) {
+					group.Keep = trackDataIndex
+				}
+			}
+
+			group.Tracks = append(group.Tracks, trackDataIndex)
+			groupOf[trackDataIndex] = group
+			if _, ok := byISRC[trackData.ISRC]; !ok && len(trackData.ISRC) > 0 {
+				byISRC[trackData.ISRC] = group
+			}
+		}
+	}
+
+	// Mark and log every copy that isn't kept
+	culled := 0
+	for _, group := range groups {
+		for _, trackDataIndex := range group.Tracks {
+			if trackDataIndex == group.Keep {
+				continue
+			}
+
+			reason := group.cullReason(cache, trackDataIndex)
+
+			trackData := &cache.TrackDatas[trackDataIndex]
+			trackData.IsDuplicate = true
+			culled++
+			fmt.Printf("  -%s, duplicate of %s from %s (%s)\n", trackData.Name, cache.TrackDatas[group.Keep].Name, TrackSource(cache, group.Keep), reason)
+			logger.DuplicateMessages = append(logger.DuplicateMessages, fmt.Sprintf("%s --- duplicate of %s --- %s\n", DescribeTrack(cache, trackDataIndex), DescribeTrack(cache, group.Keep), reason))
+		}
+	}
+
+	// Take them out of the queue, the kept copy stays where it was queued
+	queued := map[int]bool{}
+	for _, tracks := range lists {
+		kept := (*tracks)[:0]
+		for _, trackDataIndex := range *tracks {
+			if groupOf[trackDataIndex].Keep != trackDataIndex || queued[trackDataIndex] {
+				continue
+			}
+			queued[trackDataIndex] = true
+			kept = append(kept, trackDataIndex)
+		}
+		*tracks = kept
+	}
+
+	fmt.Printf("Culled %d duplicates.\n", culled)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// cullTrack is a playlist track by one artist, on the single (album 0) or
+// the album (album 1) of cullCache.
+func cullTrack(name string, album int, duration int, isrc string) Track {
+	return Track{Name: name, Artists: []CreditedArtist{{Name: "Someone", Artist: -1}}, Album: album, Artist: -1, Playlist: -1, Duration: duration, ISRC: isrc, Title: ParseTitle(name)}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func cullCache(tracks ...Track) *Cache {
+	return &Cache{
+		AlbumDatas: []Album{{Name: "Single", Type: AlbumType_Single}, {Name: "Album", Type: AlbumType_Album}},
+		TrackDatas: tracks,
+	}
+}
+
+// cull runs CullDuplicateTracks and returns the logged reasons.
+func cull(cache *Cache, adder *TrackAdder) []string {
+	var c ConfigData
+	c.User.VersionPreference = SQUE_VERSION_PREFERENCE
+
+	logger.DuplicateMessages = nil
+	CullDuplicateTracks(&c, cache, adder)
+
+	var reasons []string
+	for _, message := range logger.DuplicateMessages {
+		parts := strings.Split(strings.TrimSpace(message), " --- ")
+		reasons = append(reasons, parts[len(parts)-1])
+	}
+	return reasons
+}
+
+// checkCull compares a queue and the tracks marked IsDuplicate.
+func checkCull(t *testing.T, cache *Cache, queue []int, want []int, duplicates []int) {
+	t.Helper()
+	if fmt.Sprint(queue) != fmt.Sprint(want) {
+		t.Errorf("queue = %v, want %v", queue, want)
+	}
+
+	var marked []int
+	for i, trackData := range cache.TrackDatas {
+		if trackData.IsDuplicate {
+			marked = append(marked, i)
+		}
+	}
+	if fmt.Sprint(marked) != fmt.Sprint(duplicates) {
+		t.Errorf("duplicates = %v, want %v", marked, duplicates)
+	}
+}
+
+func TestCullDuplicateTracksByISRC(t *testing.T) {
+	cache := cullCache(
+		cullTrack("Song", 0, 200000, "XX0000000001"),
+		cullTrack("Song", 1, 201000, "XX0000000001"),
+		cullTrack("Song - Someone Remix", 0, 300000, ""),
+	)
+	adder := TrackAdder{ListenLater: []int{0, 2}, Sets: []int{1}}
+	reasons := cull(cache, &adder)
+
+	// The album version is kept where it was queued
+	checkCull(t, cache, append(adder.ListenLater, adder.Sets...), []int{2, 1}, []int{0})
+	if strings.Join(reasons, "; ") != "same ISRC XX0000000001" {
+		t.Errorf("reasons = %q", reasons)
+	}
+}
+
+func TestCullDuplicateTracksByLength(t *testing.T) {
+	// Without ISRCs only the artist, title and length tell copies apart
+	cache := cullCache(
+		cullTrack("Song", 1, 200000, ""),
+		cullTrack("Song - Radio Edit", 1, 170000, ""),
+		cullTrack("Song", 1, 201000, ""),
+		cullTrack("Song", 1, 260000, ""),
+	)
+	adder := TrackAdder{ListenLater: []int{1, 0, 2, 3}}
+	reasons := cull(cache, &adder)
+
+	// The edit is another version whatever its length, and loses to the
+	// original. The long one is another recording.
+	checkCull(t, cache, adder.ListenLater, []int{0, 3}, []int{1, 2})
+	if strings.Join(reasons, "; ") != "other version of the same song; same artist, title and length" {
+		t.Errorf("reasons = %q", reasons)
+	}
+}
+
+func TestCullDuplicateTracksAlbumCopy(t *testing.T) {
+	// The kept copy moves to the original and then to its album release
+	cache := cullCache(
+		cullTrack("Song - Radio Edit", 0, 170000, ""),
+		cullTrack("Song", 0, 200000, ""),
+		cullTrack("Song", 1, 201000, ""),
+	)
+	adder := TrackAdder{ListenLater: []int{0, 1, 2}}
+	reasons := cull(cache, &adder)
+
+	// Both are told against the album copy, not the copy they joined by
+	checkCull(t, cache, adder.ListenLater, []int{2}, []int{0, 1})
+	if strings.Join(reasons, "; ") != "other version of the same song; same artist, title and length" {
+		t.Errorf("reasons = %q", reasons)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+)
+
+// ---------------------------------------------------------
+// Doctor Types
+// ---------------------------------------------------------
+
+// doctorFeature is something SQUE-G does and the scopes it can't do it without.
+type doctorFeature struct {
+	Name   string
+	Flag   SessionFlags // 0 if every run needs it
+	Scopes []string
+}
+
+var doctorFeatures = []doctorFeature{
+	{"Scanning artists (-a)", SessionFlags_ScanArtists, []string{spotifyauth.ScopeUserFollowRead}},
+	{"Scanning playlists (-p)", SessionFlags_ScanPlaylists, []string{spotifyauth.ScopePlaylistReadPrivate}},
+	{"Printing followed playlists (-fp)", SessionFlags_PrintFollowedPlaylists, []string{spotifyauth.ScopePlaylistReadPrivate}},
+	{"Adding tracks to destinations", 0, []string{spotifyauth.ScopePlaylistModifyPublic, spotifyauth.ScopePlaylistModifyPrivate}},
+}
+
+type doctor struct {
+	client   *spotify.Client
+	config   *ConfigData
+	problems int
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (d *doctor) fail(format string, args ...interface{}) {
+	fmt.Printf("  !%s\n", fmt.Sprintf(format, args...))
+	d.problems++
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func describeSpotifyError(err error) string {
+	if spotErr, ok := err.(spotify.Error); ok {
+		switch spotErr.Status {
+		case http.StatusNotFound:
+			return "does not exist (or is not visible to you)"
+		case http.StatusForbidden:
+			return "access denied"
+		}
+	}
+	return err.Error()
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (d *doctor) checkScopes() {
+	fmt.Println("Checking granted scopes....")
+
+	tok, err := LoadCachedToken(d.config)
+	if err != nil || len(TokenScope(tok)) == 0 {
+		d.fail("Spotify didn't report the granted scopes, delete %s and log in again to check them", d.config.User.TokenPath)
+		return
+	}
+
+	granted := map[string]bool{}
+	for _, scope := range strings.Fields(TokenScope(tok)) {
+		granted[scope] = true
+	}
+
+	// Without any scan options check everything a run could do
+	checkAll := (d.config.Session.Flags & (SessionFlags_ScanArtists | SessionFlags_ScanPlaylists | SessionFlags_PrintFollowedPlaylists)) == 0
+
+	for _, feature := range doctorFeatures {
+		if !checkAll && feature.Flag != 0 && (d.config.Session.Flags&feature.Flag) == 0 {
+			continue
+		}
+
+		for _, scope := range feature.Scopes {
+			if !granted[scope] {
+				d.fail("%s needs the %s scope, which was not granted. Log in again to grant it.", feature.Name, scope)
+			}
+		}
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (d *doctor) checkDestinations() {
+	fmt.Println("Checking destination playlists....")
+
+	destinations := []struct {
+		Key string
+		ID  string
+	}{
+		{"listen_later", d.config.User.PlaylistListenLater},
+		{"sets", d.config.User.PlaylistSets},
+		{"compilation", d.config.User.PlaylistCompilation},
+	}
+
+	for _, destination := range destinations {
+		if len(destination.ID) == 0 {
+			if destination.Key == "listen_later" {
+				d.fail("listen_later is not set")
+			}
+			continue
+		}
+
+		playlist, err := d.client.GetPlaylist(context.Background(), spotify.ID(destination.ID), spotify.Fields("id,name,owner(id),collaborative"))
+		if err != nil {
+			d.fail("%s %s %s", destination.Key, destination.ID, describeSpotifyError(err))
+			continue
+		}
+
+		if playlist.Owner.ID != d.config.User.UserID && !playlist.Collaborative {
+			d.fail("%s %s (%s) is owned by %s and not collaborative, tracks can't be added to it", destination.Key, destination.ID, playlist.Name, playlist.Owner.ID)
+			continue
+		}
+
+		fmt.Printf("  *%s -- %s\n", destination.Key, playlist.Name)
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (d *doctor) checkPlaylists() {
+	fmt.Println("Checking scanned playlists....")
+
+	for i, playlistMeta := range d.config.Playlists {
+		playlist, err := d.client.GetPlaylist(context.Background(), spotify.ID(playlistMeta.ID), spotify.Fields("id,name"))
+		if err != nil {
+			d.fail("playlists[%d] %s (%s) %s", i, playlistMeta.ID, playlistMeta.Name, describeSpotifyError(err))
+			continue
+		}
+
+		if playlist.Name != playlistMeta.Name {
+			fmt.Printf("  *%s -- %s (named %s in user data)\n", playlistMeta.ID, playlist.Name, playlistMeta.Name)
+		} else {
+			fmt.Printf("  *%s -- %s\n", playlistMeta.ID, playlist.Name)
+		}
+	}
+}
+
+// RunDoctor checks the config against the live Spotify account and returns
+// the number of problems found.
+func RunDoctor(client *spotify.Client, c *ConfigData) int {
+	d := &doctor{client: client, config: c}
+
+	d.checkScopes()
+	d.checkDestinations()
+	d.checkPlaylists()
+
+	fmt.Println("----------------------------------------------")
+	if d.problems == 0 {
+		fmt.Println("No problems found.")
+	} else {
+		fmt.Printf("Found %d problems.\n", d.problems)
+	}
+
+	return d.problems
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// ---------------------------------------------------------
+// Prompts
+// ---------------------------------------------------------
+
+func promptDefault(prompt string, def string) string {
+	if len(def) > 0 {
+		prompt = fmt.Sprintf("%s [%s]", prompt, def)
+	}
+
+	answer := ReadLine(prompt + ": ")
+	if len(answer) == 0 {
+		return def
+	}
+	return answer
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func promptRequired(prompt string) string {
+	for {
+		if answer := ReadLine(prompt + ": "); len(answer) > 0 {
+			return answer
+		}
+		fmt.Println("  This is required.")
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func promptYesNo(prompt string, def bool) bool {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+
+	answer := strings.ToLower(ReadLine(fmt.Sprintf("%s [%s]: ", prompt, choices)))
+	if len(answer) == 0 {
+		return def
+	}
+	return strings.HasPrefix(answer, "y")
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func promptDate(prompt string, def time.Time) time.Time {
+	for {
+		answer := promptDefault(prompt, def.Format(SQUE_DATE_FORMAT))
+		date, err := time.Parse(SQUE_DATE_FORMAT, answer)
+		if err == nil {
+			return date
+		}
+		fmt.Println("  Expected year-month-day.")
+	}
+}
+
+// promptPlaylist asks for a playlist by its number in the printed list or by
+// ID. Optional playlists may be skipped with an empty answer.
+func promptPlaylist(prompt string, playlists []spotify.SimplePlaylist, required bool) string {
+	for {
+		answer := ReadLine(prompt + ": ")
+		if len(answer) == 0 {
+			if !required {
+				return ""
+			}
+			fmt.Println("  This is required.")
+			continue
+		}
+
+		if n, err := strconv.Atoi(answer); err == nil {
+			if n >= 1 && n <= len(playlists) {
+				return string(playlists[n-1].ID)
+			}
+			fmt.Printf("  Pick a number between 1 and %d.\n", len(playlists))
+			continue
+		}
+
+		if spotifyIDPattern.MatchString(answer) {
+			return answer
+		}
+		fmt.Println("  Expected a number from the list or a 22 character playlist ID.")
+	}
+}
+
+// ---------------------------------------------------------
+// Init Wizard
+// ---------------------------------------------------------
+
+func pickPlaylistsFromAccount(c *ConfigData) {
+	// Log in with the answers so far, and the token cache next to the new user.data
+	config.User = c.User
+	config.User.TokenPath = defaultPath(c.User.UserDataPath, SQUE_TOKEN_FILE)
+	config.User.LoginTimeout = SQUE_LOGIN_TIMEOUT
+
+	// An empty client secret may be kept in the secrets store next to it
+	if len(config.User.SecretsPath) == 0 {
+		config.User.SecretsPath = defaultPath(c.User.UserDataPath, SQUE_SECRETS_FILE)
+	}
+	LoadSecrets(&config)
+	if len(config.User.ClientSecret) == 0 {
+		config.Session.Flags |= SessionFlags_PKCE
+	}
+
+	SetupAuthenticator(&config)
+	client := Login(&config)
+
+	playlists := GetFollowedPlaylists(client, &config)
+	fmt.Println("----------------------------------------------")
+	for i, playlist := range playlists {
+		owner := ""
+		if playlist.Owner.ID != config.User.UserID {
+			owner = fmt.Sprintf(" (by %s)", playlist.Owner.ID)
+		}
+		fmt.Printf("%3d) %s -- %s%s\n", i+1, playlist.ID, playlist.Name, owner)
+	}
+	fmt.Println("----------------------------------------------")
+	fmt.Println("Pick playlists by number or ID.")
+
+	c.User.PlaylistListenLater = promptPlaylist("Listen later playlist", playlists, true)
+	c.User.PlaylistSets = promptPlaylist("Sets playlist, for tracks over 31 minutes (empty to skip)", playlists, false)
+	c.User.PlaylistCompilation = promptPlaylist("Compilation playlist (empty to skip)", playlists, false)
+
+	fmt.Println("Now pick the playlists to scan for new tracks, one per line, empty when done.")
+	for {
+		id := promptPlaylist("Playlist to scan", playlists, false)
+		if len(id) == 0 {
+			break
+		}
+
+		meta := PlaylistMetaData{ID: id, Limit: -1}
+		for _, playlist := range playlists {
+			if string(playlist.ID) == id {
+				meta.Name = playlist.Name
+			}
+		}
+		if len(meta.Name) == 0 {
+			meta.Name = promptRequired("  Name")
+		}
+
+		for {
+			limit, err := strconv.Atoi(promptDefault("  Most tracks to queue per run, -1 for all", "-1"))
+			if err == nil && limit >= -1 {
+				meta.Limit = limit
+				break
+			}
+			fmt.Println("  Expected -1 or a number of tracks.")
+		}
+
+		c.Playlists = append(c.Playlists, meta)
+	}
+}
+
+// RunInitWizard asks for everything user.data needs, seeds the last run file
+// and writes the config to path.
+func RunInitWizard(path string) {
+	if _, err := os.Stat(path); err == nil {
+		if !promptYesNo(fmt.Sprintf("%s already exists, overwrite it?", path), false) {
+			fmt.Println("Nothing written.")
+			return
+		}
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir := filepath.Dir(absPath)
+
+	var c ConfigData
+	c.User.UserDataPath = absPath
+
+	// Keep the $schema of the file being replaced
+	if data, err := ioutil.ReadFile(path); err == nil {
+		var old ConfigData
+		if UnmarshalConfig(path, data, &old) == nil {
+			c.Schema = old.Schema
+		}
+	}
+	c.Playlists = []PlaylistMetaData{}
+
+	fmt.Println("Create an app at https://developer.spotify.com/dashboard and add the redirect URI to it.")
+	c.User.ClientID = promptRequired("Client ID")
+	c.User.ClientSecret = ReadHidden("Client secret (empty to log in with PKCE, or to keep it in the secrets store): ")
+	c.User.RedirectURI = promptDefault("Redirect URI", "http://localhost:8080/callback")
+	c.User.LogsPath = promptDefault("Logs directory", filepath.Join(dir, "logs"))
+	c.User.LastRunPath = promptDefault("Last run file", filepath.Join(dir, "lastrun"))
+	c.User.PlaylistMetaPath = promptDefault("Playlist meta file", filepath.Join(dir, "playlist.meta"))
+	since := promptDate("Queue tracks released or added since", time.Now())
+
+	if promptYesNo("Log in and pick playlists from your Spotify account?", true) {
+		pickPlaylistsFromAccount(&c)
+	} else {
+		c.User.PlaylistListenLater = promptPlaylist("Listen later playlist ID", nil, true)
+		c.User.PlaylistSets = promptPlaylist("Sets playlist ID (empty to skip)", nil, false)
+		c.User.PlaylistCompilation = promptPlaylist("Compilation playlist ID (empty to skip)", nil, false)
+	}
+
+	if err := os.MkdirAll(c.User.LogsPath, 0755); err != nil {
+		log.Fatalf("Could not create logs directory: %s\n", err)
+	}
+
+	// artists first, playlists second, same as SaveLastRunDates
+	lastRun := fmt.Sprintf("%s,%s", since.Format(SQUE_DATE_FORMAT), since.Format(SQUE_DATE_FORMAT))
+	if err := ioutil.WriteFile(c.User.LastRunPath, []byte(lastRun), 0644); err != nil {
+		log.Fatalf("Could not write last run file: %s\n", err)
+	}
+
+	if err := WriteConfigFile(path, &c); err != nil {
+		log.Fatalf("Could not write %s: %s\n", path, err)
+	}
+
+	fmt.Println("----------------------------------------------")
+	PrintConfigProblems(path, ValidateConfigFile(path))
+}
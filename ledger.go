@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_LEDGER_FILE = "user.ledger" // default ledger
+
+// ---------------------------------------------------------
+// Ledger Types
+// ---------------------------------------------------------
+
+// LedgerEntry is a track that was added to a destination playlist. Entries
+// of one run share QueuedAt, the time the run was applied.
+type LedgerEntry struct {
+	URI         string    `json:"uri"`
+	ISRC        string    `json:"isrc,omitempty"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Release     string    `json:"release,omitempty"` // album type of the release it was queued from
+	QueuedAt    time.Time `json:"queued_at"`
+}
+
+// Ledger is every track ever queued, one JSON entry per line, so scans never
+// queue a track twice even after it was removed from the destination.
+type Ledger struct {
+	Path    string
+	Entries []LedgerEntry
+	uris    map[string]int
+	isrcs   map[string]int
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (l *Ledger) index(i int) {
+	entry := l.Entries[i]
+	l.uris[entry.URI] = i
+	if len(entry.ISRC) > 0 {
+		l.isrcs[entry.ISRC] = i
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func LoadLedger(path string) *Ledger {
+	l := &Ledger{Path: path, uris: map[string]int{}, isrcs: map[string]int{}}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalf("Could not open ledger: %s\n", err)
+		}
+		return l
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Fatalf("Could not parse ledger %s:%d: %s\n", path, line, err)
+		}
+		l.Entries = append(l.Entries, entry)
+		l.index(len(l.Entries) - 1)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Could not read ledger: %s\n", err)
+	}
+
+	return l
+}
+
+// Lookup finds the entry of a track by its ISRC or any of its URIs, empty
+// ones are ignored.
+func (l *Ledger) Lookup(isrc string, uris ...string) (LedgerEntry, bool) {
+	if l == nil {
+		return LedgerEntry{}, false
+	}
+
+	if i, ok := l.isrcs[isrc]; ok && len(isrc) > 0 {
+		return l.Entries[i], true
+	}
+	for _, uri := range uris {
+		if i, ok := l.uris[uri]; ok && len(uri) > 0 {
+			return l.Entries[i], true
+		}
+	}
+	return LedgerEntry{}, false
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (l *Ledger) Append(entries []LedgerEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("Could not open ledger: %s\n", err)
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			log.Fatalf("Could not write ledger: %s\n", err)
+		}
+		l.Entries = append(l.Entries, entry)
+		l.index(len(l.Entries) - 1)
+	}
+}
+
+// RemoveRun drops the entries of the run applied at queuedAt, so an undone
+// run's tracks can be queued again. It returns how many were dropped.
+func (l *Ledger) RemoveRun(queuedAt time.Time) int {
+	var kept []LedgerEntry
+	for _, entry := range l.Entries {
+		if !entry.QueuedAt.Equal(queuedAt) {
+			kept = append(kept, entry)
+		}
+	}
+
+	removed := len(l.Entries) - len(kept)
+	if removed == 0 {
+		return 0
+	}
+
+	f, err := os.Create(l.Path)
+	if err != nil {
+		log.Fatalf("Could not rewrite ledger: %s\n", err)
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	l.Entries, l.uris, l.isrcs = kept, map[string]int{}, map[string]int{}
+	for i, entry := range kept {
+		if err := encoder.Encode(entry); err != nil {
+			log.Fatalf("Could not rewrite ledger: %s\n", err)
+		}
+		l.index(i)
+	}
+
+	return removed
+}
+
+// ledgerEntries are the entries for the tracks of a destination that an
+// addition actually added.
+func ledgerEntries(destination PlanDestination, addition PlaylistAddition, queuedAt time.Time) []LedgerEntry {
+	added := map[string]bool{}
+	for _, track := range addition.Tracks {
+		added[track.URI] = true
+	}
+
+	var entries []LedgerEntry
+	for _, track := range destination.Tracks {
+		if !added[track.URI] {
+			continue
+		}
+		entries = append(entries, LedgerEntry{
+			URI:         track.URI,
+			ISRC:        track.ISRC,
+			Source:      track.Source,
+			Destination: destination.Name,
+			Release:     track.Release,
+			QueuedAt:    queuedAt,
+		})
+	}
+	return entries
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func describeLedgerEntry(entry LedgerEntry) string {
+	return fmt.Sprintf("queued to %s %s", entry.Destination, entry.QueuedAt.Format(SQUE_DATE_FORMAT))
+}
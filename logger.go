@@ -10,9 +10,11 @@ import (
 // ---------------------------------------------------------
 // ---------------------------------------------------------
 type Logger struct {
-    UnPlayableMessages []string
-	ArtistMessages     []string
-	PlaylistMessages   []string
+    UnPlayableMessages []string `json:"unplayable"`
+	ArtistMessages     []string `json:"artists"`
+	PlaylistMessages   []string `json:"playlists"`
+	DuplicateMessages  []string `json:"duplicates,omitempty"`
+	SingleMessages     []string `json:"singles,omitempty"`
 }
 
 // ---------------------------------------------------------
@@ -45,4 +47,10 @@ func WriteLogs(logger *Logger, config *ConfigData) {
     if len(logger.UnPlayableMessages) > 0 {
         writeMessage(f, fmt.Sprintf("UnPlayable, Total=%d", len(logger.UnPlayableMessages)), &logger.UnPlayableMessages)
 	}
+    if len(logger.DuplicateMessages) > 0 {
+        writeMessage(f, fmt.Sprintf("Duplicates, Total=%d", len(logger.DuplicateMessages)), &logger.DuplicateMessages)
+	}
+    if len(logger.SingleMessages) > 0 {
+        writeMessage(f, fmt.Sprintf("Album Versions Of Singles, Total=%d", len(logger.SingleMessages)), &logger.SingleMessages)
+	}
 }
\ No newline at end of file
@@ -1,10 +1,7 @@
 package main
 
 import (
-	"context"
 	"fmt"
-	"log"
-	"net/http"
 	"os"
 	"time"
 
@@ -19,77 +16,22 @@ var (
 	adder  TrackAdder
 	logger Logger
 
-	ch       = make(chan *spotify.Client)
-	appState = "abc123" // TODO: What should this be?
+	ch           = make(chan loginResult, 1)
+	appState     string // OAuth state, generated per login
+	codeVerifier string // PKCE verifier, generated per run when logging in with PKCE
 )
 
 // ---------------------------------------------------------
 // ---------------------------------------------------------
 func main() {
-	// index 0 is program name
-	// index 1 is user.data
-	args := os.Args
-
-	if len(args) < 2 {
-		log.Fatal("Not enough arguments were provided. Exiting early.\nPlease provide the absolute path to user.data.")
-	}
-
-	// Setup last run and playlist meta data
-	InitConfigData(&config, args[1])
-
-	// Load Options
-	for i := 1; i < len(args); i++ {
-		CheckOption(&config, args, i)
-	}
-
-	// ClientID, SecretID
-	auth = spotifyauth.New(spotifyauth.WithClientID(config.User.ClientID),
-		spotifyauth.WithClientSecret(config.User.ClientSecret),
-		spotifyauth.WithRedirectURL(config.User.RedirectURI),
-		spotifyauth.WithScopes(spotifyauth.ScopePlaylistModifyPublic, spotifyauth.ScopePlaylistModifyPrivate, spotifyauth.ScopePlaylistReadPrivate, spotifyauth.ScopeUserFollowRead))
-
-	// first start an HTTP server
-	http.HandleFunc("/callback", completeAuth)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Got request for:", r.URL.String())
-	})
-	go func() {
-		err := http.ListenAndServe(":8080", nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	url := auth.AuthURL(appState)
-	fmt.Println("Please log in to Spotify by visiting the following page in your browser:", url)
-
-	// wait for auth to complete
-	client := <-ch
-
-	// use the client to make calls that require authorization
-	spotifyUser, userErr := client.CurrentUser(context.Background())
-	if userErr != nil {
-		log.Fatal(userErr)
-	}
-
-	// assign user ID
-	config.User.UserID = spotifyUser.ID
-
-	fmt.Println("You are logged in as:", spotifyUser.ID)
-
-	// Print Followed Playlists
-	if (config.Session.Flags & SessionFlags_PrintFollowedPlaylists) != 0 {
-		fmt.Println("----------------------------------------------")
-		fmt.Println("Displaying followed playlists, exitting early.")
-		fmt.Println("----------------------------------------------")
-		ShowFollowedPlaylists(client, &config)
-		return
-	}
+	RunCommandLine(os.Args[1:])
+}
 
+// ScanTracks scans the categories enabled in the session flags and queues
+// the new tracks in adder.
+func ScanTracks(client *spotify.Client) {
 	InitCache(&cache)
-
-	// Start Clock
-	connectedStartTime := time.Now()
+	cache.Ledger = LoadLedger(config.User.LedgerPath)
 
 	// Scan Artists
 	if (config.Session.Flags & SessionFlags_ScanArtists) != 0 {
@@ -101,11 +43,23 @@ func main() {
 		ScanPlaylistTracks(client, &cache, &config, &adder)
 	}
 
+	// Know every followed artist on a track, not just the one it was found under.
+	// Without an artist scan the followed artists still have to be fetched
+	if (config.Session.Flags & SessionFlags_ScanArtists) == 0 {
+		if err := LoadFollowedArtists(client, &cache); err != nil {
+			fmt.Printf("Could not get followed artists, credits won't be linked: %s\n", describeSpotifyError(err))
+		}
+	}
+	LinkCredits(&cache)
+
+	// Never propose tracks rejected in earlier reviews
+	DropRejectedTracks(&config, &cache, &adder)
+
 	fmt.Println("----------------------------------------------")
 	fmt.Println("Culling duplicates...")
 	fmt.Println("----------------------------------------------")
 
-	CullDuplicateTracks(&cache)
+	CullDuplicateTracks(&config, &cache, &adder)
 
 	fmt.Println("----------------------------------------------")
 	fmt.Printf("Adder will add %d listen later\n", len(adder.ListenLater))
@@ -113,30 +67,32 @@ func main() {
 	fmt.Printf("Adder will add %d Compilations\n", len(adder.Compilations))
 	fmt.Println("----------------------------------------------")
 
-	// Add songs to playlists
-	if len(adder.ListenLater) > 0 {
-		AddTracksToPlaylist(client, &cache, config.User.PlaylistListenLater, adder.ListenLater, true)
-	}
-
-	if len(adder.Sets) > 0 {
-		AddTracksToPlaylist(client, &cache, config.User.PlaylistSets, adder.Sets, false)
+	// Let the user accept, reject and re-route the queue
+	if (config.Session.Flags & SessionFlags_Review) != 0 {
+		ReviewQueue(&config, &cache, &adder)
 	}
+}
 
-	if len(adder.Compilations) > 0 {
-		AddTracksToPlaylist(client, &cache, config.User.PlaylistCompilation, adder.Compilations, false)
-	}
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func RunScan(client *spotify.Client) {
+	// Start Clock
+	connectedStartTime := time.Now()
 
-	// Print Logs
-	if len(logger.ArtistMessages) > 0 || len(logger.PlaylistMessages) > 0 {
-		WriteLogs(&logger, &config)
-	}
+	ScanTracks(client)
+	plan := BuildPlan(&config, &cache, &adder)
 
-	if (config.Session.Flags & SessionFlags_ScanPlaylists) != 0 {
-		AlertStalePlaylistsAndSavePlaylistUpdates(&config, &cache)
+	// Dry runs only report what would be added and saved
+	if (config.Session.Flags & SessionFlags_DryRun) != 0 {
+		fmt.Println("----------------------------------------------")
+		fmt.Println("Dry run, nothing is added or saved.")
+		fmt.Println("----------------------------------------------")
+		SkipTracksInDestinations(client, plan)
+		PrintPlan(&config, plan)
+	} else {
+		ApplyPlan(client, &config, plan)
 	}
 
-	CloseAndSave(&config)
-
 	elapsedtime := time.Since(connectedStartTime)
 	fmt.Println("----------------------------------------------")
 	fmt.Printf("Done! Elapsed time to perform scan: %s.", elapsedtime)
@@ -145,22 +101,3 @@ func main() {
 		fmt.Printf("\nCompleted with %d errors.", len(logger.UnPlayableMessages))
 	}
 }
-
-// ---------------------------------------------------------
-// ---------------------------------------------------------
-func completeAuth(w http.ResponseWriter, r *http.Request) {
-	tok, err := auth.Token(r.Context(), appState, r)
-	if err != nil {
-		http.Error(w, "Couldn't get token", http.StatusForbidden)
-		log.Fatal(err)
-	}
-	if st := r.FormValue("state"); st != appState {
-		http.NotFound(w, r)
-		log.Fatalf("State mismatch: %s != %s\n", st, appState)
-	}
-
-	// use the token to get an authenticated client
-	client := spotify.New(auth.Client(r.Context(), tok), spotify.WithRetry(true))
-	fmt.Fprintf(w, "Login Completed!")
-	ch <- client
-}
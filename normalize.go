@@ -0,0 +1,207 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+// SQUE_VERSION_PREFERENCE is the default order in which copies of a
+// recording are kept, most wanted first.
+var SQUE_VERSION_PREFERENCE = []string{"original", "extended", "remaster", "edit"}
+
+var titleBracketPattern = regexp.MustCompile(`\s*[\(\[]([^\(\)\[\]]*)[\)\]]`)
+var titleFeatPattern = regexp.MustCompile(`(?i)\s+(feat\.|ft\.|featuring)\s+.*$`)
+var titleWordPattern = regexp.MustCompile(`[a-z]+`)
+
+// ---------------------------------------------------------
+// Title Types
+// ---------------------------------------------------------
+
+type VersionTags uint8
+
+const (
+	VersionTags_Feat VersionTags = 1 << iota
+	VersionTags_Edit
+	VersionTags_Remaster
+	VersionTags_Remix
+	VersionTags_Live
+	VersionTags_Extended
+)
+
+// versionNames are the names of the version tags in user data and logs.
+// Tracks with none of edit, remaster and extended are the original.
+var versionNames = []struct {
+	Name string
+	Tag  VersionTags
+}{
+	{"feat", VersionTags_Feat},
+	{"edit", VersionTags_Edit},
+	{"remaster", VersionTags_Remaster},
+	{"remix", VersionTags_Remix},
+	{"live", VersionTags_Live},
+	{"extended", VersionTags_Extended},
+}
+
+// TrackTitle is a track name split into the song and its version. Remixes
+// and live recordings are songs of their own, so what tells them apart is
+// kept in Variant.
+type TrackTitle struct {
+	Canonical string      // normalized name without the version parts
+	Variant   string      // normalized remix and live parts, like "x remix"
+	Versions  VersionTags // every version part found
+}
+
+// ---------------------------------------------------------
+// Normalizing
+// ---------------------------------------------------------
+
+// normalizeName lowercases a name and keeps only its letters and digits,
+// single spaced, so punctuation and spacing don't tell two names apart.
+func normalizeName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(name) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+		space = false
+	}
+	return b.String()
+}
+
+// parseVersionPart reads one bracketed or " - " part of a title. Parts that
+// aren't about the version, like "Part 2", return ok false.
+func parseVersionPart(part string) (tags VersionTags, ok bool) {
+	words := map[string]bool{}
+	for _, word := range titleWordPattern.FindAllString(strings.ToLower(part), -1) {
+		words[word] = true
+	}
+
+	// A bracketed or " - " part may say "Feat" without the dot
+	lower := strings.ToLower(strings.TrimSpace(part))
+	if fields := strings.Fields(lower); len(fields) > 1 {
+		switch strings.TrimSuffix(fields[0], ".") {
+		case "feat", "ft", "featuring", "with":
+			return VersionTags_Feat, true
+		}
+	}
+
+	if words["remix"] || words["rmx"] {
+		tags |= VersionTags_Remix
+	}
+	if words["live"] {
+		tags |= VersionTags_Live
+	}
+	if strings.Contains(lower, "remaster") {
+		tags |= VersionTags_Remaster
+	}
+	if words["edit"] {
+		tags |= VersionTags_Edit
+	}
+	if words["extended"] {
+		tags |= VersionTags_Extended
+	}
+	if words["original"] && (words["mix"] || words["version"]) {
+		return tags, true
+	}
+
+	return tags, tags != 0
+}
+
+// ParseTitle splits a Spotify track name like "Song (feat. X) - 2024
+// Remaster" into its song and version.
+func ParseTitle(name string) TrackTitle {
+	var title TrackTitle
+	var parts []string
+
+	// Bracketed parts first, then whatever follows " - "
+	rest := titleBracketPattern.ReplaceAllStringFunc(name, func(match string) string {
+		parts = append(parts, titleBracketPattern.FindStringSubmatch(match)[1])
+		return ""
+	})
+	segments := strings.Split(rest, " - ")
+	parts = append(parts, segments[1:]...)
+
+	song := titleFeatPattern.ReplaceAllString(segments[0], "")
+	if song != segments[0] {
+		title.Versions |= VersionTags_Feat
+	}
+
+	var kept, variant []string
+	for _, part := range parts {
+		tags, ok := parseVersionPart(part)
+		if !ok {
+			kept = append(kept, part)
+			continue
+		}
+
+		title.Versions |= tags
+		if tags&(VersionTags_Remix|VersionTags_Live) != 0 {
+			variant = append(variant, part)
+		}
+	}
+
+	title.Canonical = normalizeName(song + " " + strings.Join(kept, " "))
+	title.Variant = normalizeName(strings.Join(variant, " "))
+
+	// A name that is all version, like "Live", is its own song
+	if len(title.Canonical) == 0 {
+		title.Canonical = normalizeName(name)
+	}
+
+	return title
+}
+
+// ---------------------------------------------------------
+// Version Preference
+// ---------------------------------------------------------
+
+// VersionNames are the names of the version tags of a title, "original"
+// included when it isn't an edit, remaster or extended version.
+func (t TrackTitle) VersionNames() []string {
+	var names []string
+	for _, version := range versionNames {
+		if t.Versions&version.Tag != 0 {
+			names = append(names, version.Name)
+		}
+	}
+	if t.Versions&(VersionTags_Edit|VersionTags_Remaster|VersionTags_Extended) == 0 {
+		names = append(names, "original")
+	}
+	return names
+}
+
+// VersionRank is where the best version name of a title comes in the
+// preference order, lower is better. Titles with no listed version rank
+// last.
+func VersionRank(preference []string, title TrackTitle) int {
+	rank := len(preference)
+	for _, name := range title.VersionNames() {
+		for i, preferred := range preference {
+			if i < rank && preferred == name {
+				rank = i
+			}
+		}
+	}
+	return rank
+}
+
+// IsVersionName reports whether a version preference entry is known.
+func IsVersionName(name string) bool {
+	for _, version := range versionNames {
+		if version.Name == name {
+			return true
+		}
+	}
+	return name == "original"
+}
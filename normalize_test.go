@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestParseTitle(t *testing.T) {
+	tests := []struct {
+		name      string
+		canonical string
+		variant   string
+		versions  VersionTags
+	}{
+		{"Song", "song", "", 0},
+		{"Song (feat. Someone)", "song", "", VersionTags_Feat},
+		{"Song feat. Someone", "song", "", VersionTags_Feat},
+		{"Song (Feat Someone)", "song", "", VersionTags_Feat},
+		{"Song - Feat Someone", "song", "", VersionTags_Feat},
+		{"Defeat the Feat Song", "defeat the feat song", "", 0},
+		{"Song (Featherweight)", "song featherweight", "", 0},
+		{"Song - Radio Edit", "song", "", VersionTags_Edit},
+		{"Song - 2024 Remaster", "song", "", VersionTags_Remaster},
+		{"Song (Remastered 2011)", "song", "", VersionTags_Remaster},
+		{"Song - Extended Mix", "song", "", VersionTags_Extended},
+		{"Song - Original Mix", "song", "", 0},
+		{"Song - Someone Remix", "song", "someone remix", VersionTags_Remix},
+		{"Song - Live at Wembley", "song", "live at wembley", VersionTags_Live},
+		{"Song, Part 2", "song part 2", "", 0},
+		{"Song (Part 2)", "song part 2", "", 0},
+		{"Live", "live", "", 0},
+	}
+
+	for _, test := range tests {
+		title := ParseTitle(test.name)
+		if title.Canonical != test.canonical || title.Variant != test.variant || title.Versions != test.versions {
+			t.Errorf("ParseTitle(%q) = %+v, want {%s %s %d}", test.name, title, test.canonical, test.variant, test.versions)
+		}
+	}
+}
+
+func TestVersionRank(t *testing.T) {
+	// Most wanted first, following the default preference
+	names := []string{"Song", "Song - Extended Mix", "Song - 2024 Remaster", "Song - Radio Edit"}
+	for i, name := range names {
+		if rank := VersionRank(SQUE_VERSION_PREFERENCE, ParseTitle(name)); rank != i {
+			t.Errorf("VersionRank(%q) = %d, want %d", name, rank, i)
+		}
+	}
+
+	// A featuring credit is still the original
+	if rank := VersionRank(SQUE_VERSION_PREFERENCE, ParseTitle("Song (feat. Someone)")); rank != 0 {
+		t.Errorf("VersionRank of a featuring = %d, want 0", rank)
+	}
+
+	// Versions missing from the preference rank last
+	if rank := VersionRank([]string{"edit"}, ParseTitle("Song")); rank != 1 {
+		t.Errorf("VersionRank of an unlisted version = %d, want 1", rank)
+	}
+}
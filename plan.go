@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_PLAN_FILE = "user.plan" // default plan file
+const SQUE_PLAN_MAX_AGE = 168      // in hours, plans older than a week are stale
+
+// ---------------------------------------------------------
+// Plan Types
+// ---------------------------------------------------------
+
+type PlanTrack struct {
+	URI       string `json:"uri"`
+	LinkedURI string `json:"linked_uri,omitempty"` // track it was relinked from
+	ISRC      string `json:"isrc,omitempty"`
+	Release   string `json:"release,omitempty"`  // album type of the release
+	Replaces  string `json:"replaces,omitempty"` // single to take out of the destination
+	Name      string `json:"name"`
+	Source    string `json:"source"`
+	Reason    string `json:"reason"`
+}
+
+type PlanDestination struct {
+	Name       string      `json:"name"` // user data key of the playlist
+	PlaylistID string      `json:"playlist_id"`
+	Tracks     []PlanTrack `json:"tracks"`
+}
+
+// Plan is everything a scan adds and saves. Scans apply it right away, the
+// plan command saves it to be applied later.
+type Plan struct {
+	UserDataPath string     `json:"user_data_path"`
+	CreatedAt    time.Time  `json:"created_at"`
+	AppliedAt    *time.Time `json:"applied_at,omitempty"`
+	LastRunFile  string     `json:"last_run_file"` // contents of the last run file when planned
+
+	ScannedArtistsFrom   time.Time `json:"scanned_artists_from"`
+	ScannedPlaylistsFrom time.Time `json:"scanned_playlists_from"`
+
+	Destinations     []PlanDestination `json:"destinations"`
+	UnPlayable       []PlanTrack       `json:"unplayable,omitempty"`
+	Rejected         []PlanTrack       `json:"rejected,omitempty"` // remembered so they aren't proposed again
+	LastRunArtists   time.Time         `json:"last_run_artists"`
+	LastRunPlaylists time.Time         `json:"last_run_playlists"`
+	PlaylistUpdates  []PlaylistUpdate  `json:"playlist_updates,omitempty"` // only when playlists were scanned
+	Logs             Logger            `json:"logs"`
+}
+
+// ---------------------------------------------------------
+// Building Plans
+// ---------------------------------------------------------
+
+func planTracks(cache *Cache, tracks []int) []PlanTrack {
+	var planned []PlanTrack
+	for _, trackDataIndex := range tracks {
+		trackData := cache.TrackDatas[trackDataIndex]
+
+		release := ""
+		if trackData.Album >= 0 {
+			release = cache.AlbumDatas[trackData.Album].Type.String()
+		}
+
+		planned = append(planned, PlanTrack{
+			URI:       trackData.URI,
+			LinkedURI: trackData.LinkedURI,
+			ISRC:      trackData.ISRC,
+			Release:   release,
+			Replaces:  trackData.Replaces,
+			Name:      DescribeTrack(cache, trackDataIndex),
+			Source:    TrackSource(cache, trackDataIndex),
+			Reason:    trackData.Reason,
+		})
+	}
+	return planned
+}
+
+// BuildPlan turns the queued tracks and the dates the run would save into a
+// plan. Listen later is shuffled here so applying a plan adds exactly what
+// was reviewed.
+func BuildPlan(c *ConfigData, cache *Cache, adder *TrackAdder) *Plan {
+	plan := &Plan{
+		CreatedAt:            time.Now(),
+		ScannedArtistsFrom:   c.Session.LastRunArtists,
+		ScannedPlaylistsFrom: c.Session.LastRunPlaylists,
+		Logs:                 logger,
+	}
+
+	if absPath, err := filepath.Abs(c.User.UserDataPath); err == nil {
+		plan.UserDataPath = absPath
+	}
+	if lastRun, err := ioutil.ReadFile(c.User.LastRunPath); err == nil {
+		plan.LastRunFile = string(lastRun)
+	}
+
+	listenLater := append([]int{}, adder.ListenLater...)
+	for i := range listenLater {
+		j := rand.Intn(i + 1)
+		listenLater[i], listenLater[j] = listenLater[j], listenLater[i]
+	}
+
+	destinations := []struct {
+		Name       string
+		PlaylistID string
+		Tracks     []int
+	}{
+		{"listen_later", c.User.PlaylistListenLater, listenLater},
+		{"sets", c.User.PlaylistSets, adder.Sets},
+		{"compilation", c.User.PlaylistCompilation, adder.Compilations},
+	}
+
+	for _, destination := range destinations {
+		if len(destination.Tracks) == 0 {
+			continue
+		}
+		plan.Destinations = append(plan.Destinations, PlanDestination{
+			Name:       destination.Name,
+			PlaylistID: destination.PlaylistID,
+			Tracks:     planTracks(cache, destination.Tracks),
+		})
+	}
+
+	plan.UnPlayable = planTracks(cache, adder.UnPlayable)
+	plan.Rejected = planTracks(cache, adder.Rejected)
+
+	plan.LastRunArtists, plan.LastRunPlaylists = NextLastRunDates(c)
+
+	if (c.Session.Flags & SessionFlags_ScanPlaylists) != 0 {
+		AlertStalePlaylists(c, cache)
+		plan.PlaylistUpdates = GetPlaylistUpdates(c, cache)
+	}
+
+	return plan
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func PrintPlan(c *ConfigData, plan *Plan) {
+	for _, destination := range plan.Destinations {
+		if len(destination.PlaylistID) == 0 {
+			fmt.Printf("Would skip %d tracks for %s, no playlist is set:\n", len(destination.Tracks), destination.Name)
+		} else {
+			fmt.Printf("Would add %d tracks to %s (%s):\n", len(destination.Tracks), destination.Name, destination.PlaylistID)
+		}
+
+		for _, track := range destination.Tracks {
+			if len(track.Reason) > 0 {
+				fmt.Printf("  *%s [%s] -- %s\n", track.Name, track.URI, track.Reason)
+			} else {
+				fmt.Printf("  *%s [%s]\n", track.Name, track.URI)
+			}
+		}
+	}
+
+	if len(plan.UnPlayable) > 0 {
+		fmt.Printf("Would skip %d unplayable tracks:\n", len(plan.UnPlayable))
+		for _, track := range plan.UnPlayable {
+			fmt.Printf("  *%s\n", track.Name)
+		}
+	}
+
+	if len(plan.Rejected) > 0 {
+		fmt.Printf("Would remember %d rejected tracks in %s:\n", len(plan.Rejected), c.User.RejectedPath)
+		for _, track := range plan.Rejected {
+			fmt.Printf("  *%s\n", track.Name)
+		}
+	}
+
+	if len(plan.Logs.ArtistMessages) > 0 || len(plan.Logs.PlaylistMessages) > 0 || len(plan.Logs.SingleMessages) > 0 {
+		fmt.Printf("Would write a log to %s.\n", c.User.LogsPath)
+	}
+
+	fmt.Printf("Would save last run dates %s,%s to %s.\n", plan.LastRunArtists.Format(SQUE_DATE_FORMAT), plan.LastRunPlaylists.Format(SQUE_DATE_FORMAT), c.User.LastRunPath)
+
+	if len(plan.PlaylistUpdates) > 0 && len(c.User.PlaylistMetaPath) > 0 {
+		fmt.Printf("Would save playlist updates to %s:\n", c.User.PlaylistMetaPath)
+		for _, update := range plan.PlaylistUpdates {
+			fmt.Printf("  *%s,%s -- %s\n", update.ID, update.LastUpdated.Format(time.UnixDate), update.Name)
+		}
+	}
+}
+
+// ---------------------------------------------------------
+// Applying Plans
+// ---------------------------------------------------------
+
+// trackID is the ID of a track URI, or "" for anything that isn't a track.
+func trackID(uri string) string {
+	if strings.HasPrefix(uri, "spotify:track:") {
+		return uri[len("spotify:track:"):]
+	}
+	return ""
+}
+
+// skipTracksInPlaylist drops the tracks of a destination that its playlist
+// already holds, or that come up twice, and returns how many. Relinked
+// tracks match by either ID.
+func skipTracksInPlaylist(client *spotify.Client, destination *PlanDestination) (int, error) {
+	playlistTracks, err := GetAllPlaylistTracks(client, destination.PlaylistID)
+	if err != nil {
+		return 0, err
+	}
+
+	present := map[string]bool{}
+	for _, playlistTrack := range playlistTracks {
+		present[playlistTrack.Track.ID.String()] = true
+		if playlistTrack.Track.LinkedFrom != nil {
+			present[playlistTrack.Track.LinkedFrom.ID.String()] = true
+		}
+	}
+
+	var kept []PlanTrack
+	for _, track := range destination.Tracks {
+		id, linkedId := trackID(track.URI), trackID(track.LinkedURI)
+		if present[id] || (len(linkedId) > 0 && present[linkedId]) {
+			continue
+		}
+
+		present[id] = true
+		if len(linkedId) > 0 {
+			present[linkedId] = true
+		}
+		kept = append(kept, track)
+	}
+
+	skipped := len(destination.Tracks) - len(kept)
+	destination.Tracks = kept
+	return skipped, nil
+}
+
+// removeReplacedSingles takes the singles that planned album versions
+// replace out of the destination playlist and returns the URIs it removed,
+// as the playlist held them. Singles no longer in the playlist are left
+// alone.
+func removeReplacedSingles(client *spotify.Client, destination PlanDestination) ([]string, error) {
+	replaced := map[string]bool{}
+	for _, track := range destination.Tracks {
+		if id := trackID(track.Replaces); len(id) > 0 {
+			replaced[id] = true
+		}
+	}
+	if len(replaced) == 0 {
+		return nil, nil
+	}
+
+	playlistTracks, err := GetAllPlaylistTracks(client, destination.PlaylistID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []spotify.ID
+	var uris []string
+	for _, playlistTrack := range playlistTracks {
+		id := playlistTrack.Track.ID
+		if playlistTrack.Track.LinkedFrom != nil {
+			id = playlistTrack.Track.LinkedFrom.ID
+		}
+		if replaced[playlistTrack.Track.ID.String()] || replaced[id.String()] {
+			replaced[playlistTrack.Track.ID.String()], replaced[id.String()] = false, false
+			ids = append(ids, id)
+			uris = append(uris, "spotify:track:"+id.String())
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	if _, err := client.RemoveTracksFromPlaylist(context.Background(), spotify.ID(destination.PlaylistID), ids...); err != nil {
+		return nil, err
+	}
+	return uris, nil
+}
+
+// SkipTracksInDestinations drops the planned tracks the destination
+// playlists already hold, so reruns with overlapping dates don't add them
+// twice.
+func SkipTracksInDestinations(client *spotify.Client, plan *Plan) {
+	var destinations []PlanDestination
+	for _, destination := range plan.Destinations {
+		if len(destination.PlaylistID) > 0 {
+			skipped, err := skipTracksInPlaylist(client, &destination)
+			if err != nil {
+				fmt.Printf("Could not check %s for tracks it already has: %s\n", destination.Name, describeSpotifyError(err))
+			} else if skipped > 0 {
+				fmt.Printf("Skipping %d tracks already in %s.\n", skipped, destination.Name)
+			}
+		}
+
+		if len(destination.Tracks) > 0 {
+			destinations = append(destinations, destination)
+		}
+	}
+	plan.Destinations = destinations
+}
+
+// ApplyPlan adds the planned tracks to their playlists, writes the logs and
+// saves the run dates.
+func ApplyPlan(client *spotify.Client, c *ConfigData, plan *Plan) {
+	// Keep what this run changes so it can be undone
+	run := RunRecord{AppliedAt: time.Now(), LastRunFile: readFileOrEmpty(c.User.LastRunPath)}
+	if len(plan.PlaylistUpdates) > 0 && len(c.User.PlaylistMetaPath) > 0 {
+		playlistMeta := readFileOrEmpty(c.User.PlaylistMetaPath)
+		run.PlaylistMetaFile = &playlistMeta
+	}
+
+	SkipTracksInDestinations(client, plan)
+	ledger := LoadLedger(c.User.LedgerPath)
+
+	// Add songs to playlists
+	for _, destination := range plan.Destinations {
+		if len(destination.PlaylistID) == 0 {
+			fmt.Printf("No %s playlist is set, skipping %d tracks.\n", destination.Name, len(destination.Tracks))
+			continue
+		}
+
+		uris := make([]string, len(destination.Tracks))
+		for i, track := range destination.Tracks {
+			uris[i] = track.URI
+		}
+
+		// Album versions take the place of their singles
+		removed, err := removeReplacedSingles(client, destination)
+		if err != nil {
+			fmt.Printf("Could not remove the singles replaced in %s: %s\n", destination.Name, describeSpotifyError(err))
+		} else if len(removed) > 0 {
+			fmt.Printf("Removed %d singles replaced by their album version from %s.\n", len(removed), destination.Name)
+			run.Removals = append(run.Removals, PlaylistRemoval{Name: destination.Name, PlaylistID: destination.PlaylistID, URIs: removed})
+		}
+
+		fmt.Printf("Adding %d tracks to %s....\n", len(uris), destination.Name)
+		addition := AddTracksToPlaylist(client, destination.PlaylistID, uris)
+		if len(addition.Tracks) > 0 {
+			addition.Name = destination.Name
+			run.Additions = append(run.Additions, addition)
+		}
+		ledger.Append(ledgerEntries(destination, addition, run.AppliedAt))
+	}
+
+	if len(plan.Rejected) > 0 {
+		uris := make([]string, len(plan.Rejected))
+		for i, track := range plan.Rejected {
+			uris[i] = track.URI
+		}
+		SaveRejectedTracks(c.User.RejectedPath, uris)
+	}
+
+	// Print Logs, headed with the dates that were scanned from
+	if len(plan.Logs.ArtistMessages) > 0 || len(plan.Logs.PlaylistMessages) > 0 || len(plan.Logs.SingleMessages) > 0 {
+		c.Session.LastRunArtists = plan.ScannedArtistsFrom
+		c.Session.LastRunPlaylists = plan.ScannedPlaylistsFrom
+		WriteLogs(&plan.Logs, c)
+	}
+
+	if len(plan.PlaylistUpdates) > 0 && len(c.User.PlaylistMetaPath) > 0 {
+		SavePlaylistUpdates(c.User.PlaylistMetaPath, plan.PlaylistUpdates)
+	}
+
+	SaveLastRunDates(c.User.LastRunPath, plan.LastRunArtists, plan.LastRunPlaylists)
+
+	RecordRun(c, run)
+}
+
+// CheckPlan returns why a saved plan can no longer be applied, or nil.
+func CheckPlan(c *ConfigData, plan *Plan) error {
+	if plan.AppliedAt != nil {
+		return fmt.Errorf("The plan was already applied at %s.", plan.AppliedAt.Format(time.RFC1123))
+	}
+
+	if absPath, err := filepath.Abs(c.User.UserDataPath); err == nil && absPath != plan.UserDataPath {
+		return fmt.Errorf("The plan was made for %s, not %s.", plan.UserDataPath, absPath)
+	}
+
+	if time.Since(plan.CreatedAt).Hours() >= SQUE_PLAN_MAX_AGE {
+		return fmt.Errorf("The plan is stale, it was made at %s. Make a new one.", plan.CreatedAt.Format(time.RFC1123))
+	}
+
+	lastRun, err := ioutil.ReadFile(c.User.LastRunPath)
+	if err != nil {
+		return err
+	}
+	if string(lastRun) != plan.LastRunFile {
+		return fmt.Errorf("The plan is stale, %s changed since it was made. Make a new one.", c.User.LastRunPath)
+	}
+
+	playlistIDs := map[string]string{
+		"listen_later": c.User.PlaylistListenLater,
+		"sets":         c.User.PlaylistSets,
+		"compilation":  c.User.PlaylistCompilation,
+	}
+	for _, destination := range plan.Destinations {
+		if playlistIDs[destination.Name] != destination.PlaylistID {
+			return fmt.Errorf("The plan is stale, %s changed in user data since it was made. Make a new one.", destination.Name)
+		}
+	}
+
+	return nil
+}
+
+// ---------------------------------------------------------
+// Plan Files
+// ---------------------------------------------------------
+
+func LoadPlan(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("Could not parse plan %s: %s", path, err)
+	}
+	return plan, nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func SavePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
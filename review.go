@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_REJECTED_FILE = "user.rejected" // default rejected tracks
+
+const reviewHelp = "j/k move, space accept/reject, a/x accept/reject source, A/X all, d/D re-route track/source, enter done, ctrl-c abort"
+
+// ---------------------------------------------------------
+// Rejected Tracks
+// ---------------------------------------------------------
+
+func LoadRejectedTracks(path string) map[string]bool {
+	rejected := map[string]bool{}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return rejected
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if uri := strings.TrimSpace(scanner.Text()); len(uri) > 0 {
+			rejected[uri] = true
+		}
+	}
+	return rejected
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func SaveRejectedTracks(path string, uris []string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, uri := range uris {
+		f.WriteString(uri + "\n")
+	}
+}
+
+// DropRejectedTracks takes the tracks rejected in earlier reviews out of the
+// queue.
+func DropRejectedTracks(c *ConfigData, cache *Cache, adder *TrackAdder) {
+	rejected := LoadRejectedTracks(c.User.RejectedPath)
+	if len(rejected) == 0 {
+		return
+	}
+
+	dropped := 0
+	for _, tracks := range []*[]int{&adder.ListenLater, &adder.Sets, &adder.Compilations} {
+		kept := (*tracks)[:0]
+		for _, trackDataIndex := range *tracks {
+			if rejected[cache.TrackDatas[trackDataIndex].URI] {
+				dropped++
+				continue
+			}
+			kept = append(kept, trackDataIndex)
+		}
+		*tracks = kept
+	}
+
+	if dropped > 0 {
+		fmt.Printf("Dropped %d tracks rejected in earlier reviews.\n", dropped)
+	}
+}
+
+// ---------------------------------------------------------
+// Review Types
+// ---------------------------------------------------------
+
+type reviewItem struct {
+	Track       int
+	Origin      int // destination the scan picked
+	Destination int // index into review.destinations
+	Rejected    bool
+}
+
+type reviewDestination struct {
+	Name       string
+	PlaylistID string
+	Tracks     *[]int
+}
+
+type review struct {
+	cache        *Cache
+	destinations []reviewDestination
+	items        []reviewItem
+	cursor       int
+	top          int
+	message      string
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) > width {
+		runes := []rune(s)
+		s = string(runes[:width-1]) + "~"
+	}
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func formatDuration(ms int) string {
+	seconds := ms / 1000
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (r *review) row(item reviewItem, width int) string {
+	trackData := r.cache.TrackDatas[item.Track]
+
+	mark := "[x]"
+	if item.Rejected {
+		mark = "[ ]"
+	}
+
+	album, released := "", ""
+	if trackData.Album >= 0 {
+		albumData := r.cache.AlbumDatas[trackData.Album]
+		album = albumData.Name
+		released = albumData.ReleaseDate.Format(SQUE_DATE_FORMAT)
+	}
+
+	columns := reviewColumns(width)
+	line := fmt.Sprintf("%s %s %s %s %s %s %s %s",
+		mark,
+		fit(r.destinations[item.Destination].Name, 12),
+		fit(trackData.Credits(), columns[0]),
+		fit(trackData.Name, columns[1]),
+		fit(album, columns[2]),
+		fit(released, 10),
+		fit(formatDuration(trackData.Duration), 6),
+		TrackSource(r.cache, item.Track))
+
+	return fit(line, width)
+}
+
+// reviewColumns splits what the fixed columns leave of the terminal between
+// the artist, track and album columns, the source gets the rest.
+func reviewColumns(width int) [3]int {
+	flex := width - 3 - 12 - 10 - 6 - 7
+	if flex < 30 {
+		flex = 30
+	}
+	return [3]int{flex * 25 / 100, flex * 30 / 100, flex * 25 / 100}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (r *review) draw() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	// title, column names, help and message lines
+	rows := height - 4
+	if rows < 1 {
+		rows = 1
+	}
+	if r.cursor < r.top {
+		r.top = r.cursor
+	}
+	if r.cursor >= r.top+rows {
+		r.top = r.cursor - rows + 1
+	}
+
+	accepted := 0
+	for _, item := range r.items {
+		if !item.Rejected {
+			accepted++
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString(fit(fmt.Sprintf("Review queue: %d of %d tracks accepted", accepted, len(r.items)), width) + "\r\n")
+	columns := reviewColumns(width)
+	b.WriteString(fit(fmt.Sprintf("    %s %s %s %s %s %s %s", fit("Playlist", 12), fit("Artist", columns[0]), fit("Track", columns[1]), fit("Album", columns[2]), fit("Released", 10), fit("Length", 6), "Source"), width) + "\r\n")
+
+	for i := r.top; i < len(r.items) && i < r.top+rows; i++ {
+		line := r.row(r.items[i], width)
+		if i == r.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		b.WriteString(line + "\r\n")
+	}
+
+	b.WriteString(fit(reviewHelp, width) + "\r\n")
+	b.WriteString(fit(r.message, width))
+	os.Stdout.WriteString(b.String())
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (r *review) sameSource(i int, j int) bool {
+	return TrackSource(r.cache, r.items[i].Track) == TrackSource(r.cache, r.items[j].Track)
+}
+
+// nextDestination is the next destination with a playlist set, in the order
+// listen later, sets, compilation.
+func (r *review) nextDestination(current int) int {
+	for step := 1; step < len(r.destinations); step++ {
+		next := (current + step) % len(r.destinations)
+		if len(r.destinations[next].PlaylistID) > 0 {
+			return next
+		}
+	}
+	return current
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (r *review) handle(key string) bool {
+	r.message = ""
+	source := TrackSource(r.cache, r.items[r.cursor].Track)
+
+	switch key {
+	case "\x1b[A", "k":
+		if r.cursor > 0 {
+			r.cursor--
+		}
+	case "\x1b[B", "j":
+		if r.cursor < len(r.items)-1 {
+			r.cursor++
+		}
+	case "\x1b[5~":
+		r.cursor -= 10
+		if r.cursor < 0 {
+			r.cursor = 0
+		}
+	case "\x1b[6~":
+		r.cursor += 10
+		if r.cursor >= len(r.items) {
+			r.cursor = len(r.items) - 1
+		}
+	case " ":
+		r.items[r.cursor].Rejected = !r.items[r.cursor].Rejected
+	case "a", "x":
+		for i := range r.items {
+			if r.sameSource(i, r.cursor) {
+				r.items[i].Rejected = key == "x"
+			}
+		}
+		r.message = fmt.Sprintf("Updated every track from %s.", source)
+	case "A", "X":
+		for i := range r.items {
+			r.items[i].Rejected = key == "X"
+		}
+	case "d":
+		r.items[r.cursor].Destination = r.nextDestination(r.items[r.cursor].Destination)
+	case "D":
+		destination := r.nextDestination(r.items[r.cursor].Destination)
+		for i := range r.items {
+			if r.sameSource(i, r.cursor) {
+				r.items[i].Destination = destination
+			}
+		}
+		r.message = fmt.Sprintf("Sent every track from %s to %s.", source, r.destinations[destination].Name)
+	case "\r", "\n":
+		return false
+	}
+
+	return true
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (r *review) run() bool {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		log.Fatalf("Could not start the review: %s\n", err)
+	}
+	defer term.Restore(fd, state)
+	defer os.Stdout.WriteString("\x1b[H\x1b[2J")
+
+	buf := make([]byte, 16)
+	for {
+		r.draw()
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return false
+		}
+
+		key := string(buf[:n])
+		if key == "\x03" { // ctrl-c
+			return false
+		}
+		if !r.handle(key) {
+			return true
+		}
+	}
+}
+
+// ReviewQueue lets the user accept, reject and re-route every queued track in
+// the terminal. Rejected tracks move to adder.Rejected.
+func ReviewQueue(c *ConfigData, cache *Cache, adder *TrackAdder) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		log.Fatal("Reviewing the queue needs a terminal.")
+	}
+
+	r := &review{
+		cache: cache,
+		destinations: []reviewDestination{
+			{"listen_later", c.User.PlaylistListenLater, &adder.ListenLater},
+			{"sets", c.User.PlaylistSets, &adder.Sets},
+			{"compilation", c.User.PlaylistCompilation, &adder.Compilations},
+		},
+	}
+
+	for destinationIndex, destination := range r.destinations {
+		for _, trackDataIndex := range *destination.Tracks {
+			r.items = append(r.items, reviewItem{Track: trackDataIndex, Origin: destinationIndex, Destination: destinationIndex})
+		}
+	}
+
+	if len(r.items) == 0 {
+		fmt.Println("Nothing to review.")
+		return
+	}
+
+	if !r.run() {
+		fmt.Println("Review aborted, nothing was added or saved.")
+		os.Exit(1)
+	}
+
+	for _, destination := range r.destinations {
+		*destination.Tracks = nil
+	}
+
+	rerouted := 0
+	for _, item := range r.items {
+		if item.Rejected {
+			adder.Rejected = append(adder.Rejected, item.Track)
+			continue
+		}
+
+		destination := r.destinations[item.Destination]
+		*destination.Tracks = append(*destination.Tracks, item.Track)
+
+		if item.Destination != item.Origin {
+			trackData := &cache.TrackDatas[item.Track]
+			if len(trackData.Reason) > 0 {
+				trackData.Reason += ", "
+			}
+			trackData.Reason += fmt.Sprintf("re-routed from %s in review", r.destinations[item.Origin].Name)
+			rerouted++
+		}
+	}
+
+	fmt.Printf("Reviewed %d tracks, rejected %d and re-routed %d.\n", len(r.items), len(adder.Rejected), rerouted)
+}
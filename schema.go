@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_SCHEMA_DRAFT = "http://json-schema.org/draft-07/schema#"
+
+// descriptions of the config keys, keyed by path with [] for array items.
+// Keys without one still show up in the schema.
+var schemaDescriptions = map[string]string{
+	"$schema":                 "JSON Schema of this file, for editors.",
+	"user":                    "Spotify app credentials, file locations and destination playlists.",
+	"user.client_id":          "Client ID of your Spotify app.",
+	"user.client_secret":      "Client secret of your Spotify app. Leave out to log in with PKCE or to keep it in the secrets store.",
+	"user.redirect_uri":       "Redirect URI registered with the Spotify app, the callback server listens on its port and path.",
+	"user.callback_listen":    "Address the callback server binds to instead of loopback.",
+	"user.logs_path":          "Directory the run logs are written to.",
+	"user.last_run_path":      "File holding the last artist and playlist run dates, year-month-day,year-month-day.",
+	"user.playlist_meta_path": "File the last update of every scanned playlist is saved to.",
+	"user.token_path":         "OAuth token cache, user.token next to this file by default.",
+	"user.secrets_path":       "Encrypted secrets store, user.secrets next to this file by default.",
+	"user.rejected_path":      "Tracks rejected in reviews, which are never queued again. user.rejected next to this file by default.",
+	"user.runs_path":          "What the last runs added and saved, for undo. user.runs next to this file by default.",
+	"user.backups_path":       "Directory playlist backups are written to, backups next to this file by default.",
+	"user.ledger_path":        "Every track ever queued, which is never queued again. user.ledger next to this file by default.",
+	"user.version_preference": "Which copy of a recording is kept, most wanted first: original, feat, edit, remaster, remix, live, extended. original, extended, remaster, edit by default.",
+	"user.replace_singles":    "Replace a queued single in its playlist with the album version when the album comes out, instead of skipping the album version.",
+	"user.login_timeout":      "Seconds to wait for the browser login, 300 by default.",
+	"user.listen_later":       "Playlist new tracks are added to.",
+	"user.compilation":        "Playlist compilations are added to.",
+	"user.sets":               "Playlist tracks over 31 minutes are added to.",
+	"playlists":               "Playlists scanned for newly added tracks.",
+	"playlists[].name":        "Name shown in the logs.",
+	"playlists[].id":          "Spotify ID of the playlist.",
+	"playlists[].limit":       "Most tracks queued from this playlist per run, most popular first. -1 for no limit.",
+}
+
+// ---------------------------------------------------------
+// Schema Types
+// ---------------------------------------------------------
+
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func isSpotifyIDPath(path string) bool {
+	if path == "playlists[].id" {
+		return true
+	}
+	for _, key := range configPlaylistUserKeys {
+		if path == "user."+key {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaFor describes a Go type the way json.Unmarshal reads it, so every
+// field with a json key is in the schema without listing it here.
+func schemaFor(t reflect.Type, path string) *jsonSchema {
+	s := &jsonSchema{
+		Type:        schemaKind(t),
+		Description: schemaDescriptions[path],
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		closed := false
+		s.AdditionalProperties = &closed
+		s.Properties = map[string]*jsonSchema{}
+
+		for i := 0; i < t.NumField(); i++ {
+			key := jsonKey(t.Field(i))
+			if len(key) == 0 {
+				continue
+			}
+			s.Properties[key] = schemaFor(t.Field(i).Type, joinConfigPath(path, key))
+		}
+	case reflect.Slice:
+		s.Items = schemaFor(t.Elem(), path+"[]")
+	}
+
+	if isSpotifyIDPath(path) {
+		s.Pattern = spotifyIDPattern.String()
+	}
+
+	switch path {
+	case "user":
+		s.Required = configRequiredUserKeys
+	case "playlists[]":
+		s.Required = []string{"id", "limit"}
+	case "playlists[].limit":
+		noLimit := -1
+		s.Minimum = &noLimit
+	}
+
+	return s
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func ConfigSchema() *jsonSchema {
+	s := schemaFor(reflect.TypeOf(ConfigData{}), "")
+	s.Schema = SQUE_SCHEMA_DRAFT
+	s.Title = "SQUE-G user data"
+	return s
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func PrintConfigSchema() {
+	data, err := json.MarshalIndent(ConfigSchema(), "", "    ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_SECRETS_FILE = "user.secrets"           // default secrets store
+const SQUE_SECRETS_PASSPHRASE = "SQUEG_PASSPHRASE" // environment variable checked before prompting
+
+// scrypt parameters, the recommended interactive values
+const SQUE_SECRETS_SCRYPT_N = 1 << 15
+const SQUE_SECRETS_SCRYPT_R = 8
+const SQUE_SECRETS_SCRYPT_P = 1
+
+// ---------------------------------------------------------
+// Secrets Types
+// ---------------------------------------------------------
+
+// Secrets is everything that must not sit in plaintext next to user.data.
+type Secrets struct {
+	ClientSecret string       `json:"client_secret"`
+	Token        *CachedToken `json:"token,omitempty"`
+}
+
+// SecretsStore is an opened secrets file and the passphrase to write it back.
+type SecretsStore struct {
+	Path       string
+	Secrets    Secrets
+	passphrase []byte
+}
+
+// secretsFile is the on disk form, the Secrets json sealed with AES-256-GCM
+// under a key derived from the passphrase with scrypt.
+type secretsFile struct {
+	Version    int    `json:"version"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func ReadLine(prompt string) string {
+	fmt.Print(prompt)
+	line, _ := stdinReader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func ReadHidden(prompt string) string {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return ReadLine(prompt)
+	}
+
+	fmt.Print(prompt)
+	data, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func secretsAEAD(passphrase []byte, f *secretsFile) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, f.Salt, f.N, f.R, f.P, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func OpenSecretsStore(path string, passphrase []byte) (*SecretsStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f secretsFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("could not read secrets file %s: %s", path, err)
+	}
+
+	aead, err := secretsAEAD(passphrase, &f)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("could not decrypt secrets file, wrong passphrase?")
+	}
+
+	store := &SecretsStore{Path: path, passphrase: passphrase}
+	if err = json.Unmarshal(plaintext, &store.Secrets); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (s *SecretsStore) Save() error {
+	plaintext, err := json.Marshal(&s.Secrets)
+	if err != nil {
+		return err
+	}
+
+	// fresh salt and nonce on every write, never reuse a nonce with the same key
+	f := secretsFile{
+		Version: 1,
+		N:       SQUE_SECRETS_SCRYPT_N,
+		R:       SQUE_SECRETS_SCRYPT_R,
+		P:       SQUE_SECRETS_SCRYPT_P,
+		Salt:    make([]byte, 16),
+	}
+	if _, err = rand.Read(f.Salt); err != nil {
+		return err
+	}
+
+	aead, err := secretsAEAD(s.passphrase, &f)
+	if err != nil {
+		return err
+	}
+
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(&f, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.Path, data, 0600)
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func readPassphrase() []byte {
+	if pass, ok := os.LookupEnv(SQUE_SECRETS_PASSPHRASE); ok {
+		return []byte(pass)
+	}
+	return []byte(ReadHidden("Secrets passphrase: "))
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func LoadSecrets(c *ConfigData) {
+	if _, err := os.Stat(c.User.SecretsPath); err != nil {
+		// No secrets store, everything comes from user.data
+		return
+	}
+
+	store, err := OpenSecretsStore(c.User.SecretsPath, readPassphrase())
+	if err != nil {
+		log.Fatalf("Could not open secrets store: %s\n", err)
+	}
+
+	// The environment still wins over the store
+	if len(store.Secrets.ClientSecret) > 0 && !envOverrides("client_secret") {
+		c.User.ClientSecret = store.Secrets.ClientSecret
+	}
+	c.Session.Secrets = store
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func RotateSecrets(c *ConfigData) {
+	store := c.Session.Secrets
+	if store == nil {
+		fmt.Printf("Creating secrets store %s\n", c.User.SecretsPath)
+		store = &SecretsStore{Path: c.User.SecretsPath}
+		store.Secrets.ClientSecret = c.User.ClientSecret
+	} else {
+		fmt.Printf("Rotating secrets store %s\n", c.User.SecretsPath)
+	}
+
+	secret := ReadHidden("Client secret (leave empty to keep the current one): ")
+	if len(secret) > 0 {
+		store.Secrets.ClientSecret = secret
+	}
+
+	// Move a plaintext cached token into the store
+	movedToken := false
+	if store.Secrets.Token == nil {
+		if tok, err := LoadToken(c.User.TokenPath); err == nil {
+			store.Secrets.Token = NewCachedToken(tok)
+			movedToken = true
+		}
+	}
+
+	passphrase := ReadHidden("New passphrase: ")
+	if len(passphrase) == 0 {
+		log.Fatal("The passphrase may not be empty. Exiting early.")
+	}
+	if ReadHidden("Repeat new passphrase: ") != passphrase {
+		log.Fatal("Passphrases do not match. Exiting early.")
+	}
+	store.passphrase = []byte(passphrase)
+
+	if err := store.Save(); err != nil {
+		log.Fatalf("Could not write secrets store: %s\n", err)
+	}
+
+	if movedToken {
+		os.Remove(c.User.TokenPath)
+	}
+
+	fmt.Println("Secrets saved. client_secret can now be removed from user.data.")
+}
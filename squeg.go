@@ -3,12 +3,11 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -22,6 +21,8 @@ import (
 
 const SQUE_DATE_FORMAT = "2006-01-02"  // '2006' for YYYY, '01' for MM, '02' for DD, Equivalent to YYYY-MM-DD
 const SQUE_ALERT_STALE_PLAYLIST = 4800 // in hours, 200 days
+const SQUE_TOKEN_FILE = "user.token"   // default token cache
+const SQUE_LOGIN_TIMEOUT = 300         // in seconds, how long to wait for the browser login
 
 const SQUE_SPOTIFY_LIMIT_TRACKS = 20
 const SQUE_SPOTIFY_LIMIT_ARTISTS = 50
@@ -34,17 +35,27 @@ const SQUE_SPOTIFY_MARKET = "US"
 // ---------------------------------------------------------
 
 type UserData struct {
-	UserID              string
-	UserDataPath        string
-	ClientID            string `json:"client_id"`
-	ClientSecret        string `json:"client_secret"`
-	RedirectURI         string `json:"redirect_uri"`
-	LogsPath            string `json:"logs_path"`
-	LastRunPath         string `json:"last_run_path"`
-	PlaylistMetaPath    string `json:"playlist_meta_path"`
-	PlaylistListenLater string `json:"listen_later"`
-	PlaylistCompilation string `json:"compilation"`
-	PlaylistSets        string `json:"sets"`
+	UserID              string   `json:"-"`
+	UserDataPath        string   `json:"-"`
+	ClientID            string   `json:"client_id"`
+	ClientSecret        string   `json:"client_secret,omitempty"`
+	RedirectURI         string   `json:"redirect_uri"`
+	CallbackListen      string   `json:"callback_listen,omitempty"`
+	LogsPath            string   `json:"logs_path"`
+	LastRunPath         string   `json:"last_run_path"`
+	PlaylistMetaPath    string   `json:"playlist_meta_path,omitempty"`
+	TokenPath           string   `json:"token_path,omitempty"`
+	SecretsPath         string   `json:"secrets_path,omitempty"`
+	RejectedPath        string   `json:"rejected_path,omitempty"`
+	RunsPath            string   `json:"runs_path,omitempty"`
+	BackupsPath         string   `json:"backups_path,omitempty"`
+	LedgerPath          string   `json:"ledger_path,omitempty"`
+	VersionPreference   []string `json:"version_preference,omitempty"`
+	ReplaceSingles      bool     `json:"replace_singles,omitempty"`
+	LoginTimeout        int      `json:"login_timeout,omitempty"`
+	PlaylistListenLater string   `json:"listen_later"`
+	PlaylistCompilation string   `json:"compilation,omitempty"`
+	PlaylistSets        string   `json:"sets,omitempty"`
 }
 
 type SessionFlags uint8
@@ -53,6 +64,11 @@ const (
 	SessionFlags_ScanPlaylists SessionFlags = 1 << iota
 	SessionFlags_ScanArtists
 	SessionFlags_PrintFollowedPlaylists
+	SessionFlags_PKCE
+	SessionFlags_Headless
+	SessionFlags_NoQRCode
+	SessionFlags_DryRun
+	SessionFlags_Review
 )
 
 type SessionData struct {
@@ -60,6 +76,7 @@ type SessionData struct {
 	CurrentDateTime  time.Time
 	LastRunArtists   time.Time
 	LastRunPlaylists time.Time
+	Secrets          *SecretsStore // nil unless a secrets store was opened
 }
 
 type PlaylistMetaData struct {
@@ -73,24 +90,39 @@ func (p *PlaylistMetaData) Unbounded() bool {
 }
 
 type ConfigData struct {
-	User      UserData
-	Session   SessionData
-	Playlists []PlaylistMetaData
+	Schema    string             `json:"$schema,omitempty"`
+	User      UserData           `json:"user"`
+	Session   SessionData        `json:"-"`
+	Playlists []PlaylistMetaData `json:"playlists"`
 }
 
 // ---------------------------------------------------------
 // Queuer Types
 // ---------------------------------------------------------
 
+// CreditedArtist is one artist credited on a track.
+type CreditedArtist struct {
+	ID     string
+	Name   string
+	Artist int // index into Cache.ArtistDatas when followed, -1 otherwise
+}
+
 type Track struct {
 	URI         string
 	Name        string
-	Artist      int
+	Artist      int // followed artist the track was found under, -1 for playlist tracks
+	Artists     []CreditedArtist
 	Album       int
 	Playlist    int
 	Score       int
 	DateTime    time.Time
 	IsDuplicate bool
+	Reason      string // why the track was queued
+	Duration    int    // in milliseconds
+	LinkedURI   string // track this one was relinked from, if any
+	ISRC        string // recording code, shared by every release of a recording
+	Title       TrackTitle
+	Replaces    string // single this album version replaces in its destination
 }
 
 type AlbumType int
@@ -137,6 +169,16 @@ type Cache struct {
 
 	ArtistDatas    []Artist
 	ArtistDatasMap map[string]int
+
+	Ledger *Ledger // every track queued by earlier runs
+}
+
+// PlaylistUpdate is when a scanned playlist last had a track added, as saved
+// to the playlist meta file.
+type PlaylistUpdate struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	LastUpdated time.Time `json:"last_updated"`
 }
 
 type TrackAdder struct {
@@ -144,6 +186,19 @@ type TrackAdder struct {
 	Sets         []int
 	Compilations []int
 	UnPlayable   []int
+	Rejected     []int // rejected in the review, never proposed again
+}
+
+// Destination is the queue of a destination key, listen later for unknown
+// keys.
+func (a *TrackAdder) Destination(name string) *[]int {
+	switch name {
+	case "sets":
+		return &a.Sets
+	case "compilation":
+		return &a.Compilations
+	}
+	return &a.ListenLater
 }
 
 // ---------------------------------------------------------
@@ -155,6 +210,12 @@ func InitCache(c *Cache) {
 	c.ArtistDatasMap = make(map[string]int)
 }
 
+// defaultPath is where a file of the user goes when user data doesn't say,
+// next to user.data.
+func defaultPath(userDataPath string, name string) string {
+	return filepath.Join(filepath.Dir(userDataPath), name)
+}
+
 // ---------------------------------------------------------
 // ---------------------------------------------------------
 func InitConfigData(c *ConfigData, userDataPath string) {
@@ -164,14 +225,68 @@ func InitConfigData(c *ConfigData, userDataPath string) {
 		log.Fatalf("Could not load user data path: %s\n", configErr)
 	}
 
-	// unmarshall it, copy to object
-	configErr = json.Unmarshal(configBytes, c)
+	// unmarshall it (json, yaml or toml), copy to object
+	configErr = UnmarshalConfig(userDataPath, configBytes, c)
 	if configErr != nil {
 		log.Fatalf("%s\n", configErr)
 	}
 
+	// SQUEG_* environment variables win over the file
+	if errs := ApplyEnvOverrides(&c.User); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		log.Fatal("Fix the environment variables above.")
+	}
+
 	c.User.UserDataPath = userDataPath
 
+	if c.User.LoginTimeout <= 0 {
+		c.User.LoginTimeout = SQUE_LOGIN_TIMEOUT
+	}
+
+	// Cache the oauth token next to user.data unless told otherwise
+	if len(c.User.TokenPath) == 0 {
+		c.User.TokenPath = defaultPath(userDataPath, SQUE_TOKEN_FILE)
+	}
+
+	// Same for the secrets store, which is only used once it has been created
+	if len(c.User.SecretsPath) == 0 {
+		c.User.SecretsPath = defaultPath(userDataPath, SQUE_SECRETS_FILE)
+	}
+	LoadSecrets(c)
+
+	// Without a client secret from the file, the environment or the store,
+	// the only way in is PKCE
+	if len(c.User.ClientSecret) == 0 {
+		c.Session.Flags |= SessionFlags_PKCE
+	}
+
+	// Tracks rejected in reviews
+	if len(c.User.RejectedPath) == 0 {
+		c.User.RejectedPath = defaultPath(userDataPath, SQUE_REJECTED_FILE)
+	}
+
+	// What every run added, for undo
+	if len(c.User.RunsPath) == 0 {
+		c.User.RunsPath = defaultPath(userDataPath, SQUE_RUNS_FILE)
+	}
+
+	// Playlist backups
+	if len(c.User.BackupsPath) == 0 {
+		c.User.BackupsPath = defaultPath(userDataPath, SQUE_BACKUPS_DIR)
+	}
+
+	// Every track ever queued
+	if len(c.User.LedgerPath) == 0 {
+		c.User.LedgerPath = defaultPath(userDataPath, SQUE_LEDGER_FILE)
+	}
+
+	// Which copy of a recording is kept
+	if len(c.User.VersionPreference) == 0 {
+		c.User.VersionPreference = SQUE_VERSION_PREFERENCE
+	}
+
 	lastRunArtists, lastRunPlaylists := parseLastRunFile(c.User.LastRunPath)
 
 	// Artists last run
@@ -197,30 +312,34 @@ func InitConfigData(c *ConfigData, userDataPath string) {
 
 // ---------------------------------------------------------
 // ---------------------------------------------------------
-func CloseAndSave(c *ConfigData) {
-	f, err := os.Create(c.User.LastRunPath)
+func SaveLastRunDates(path string, lastRunArtists time.Time, lastRunPlaylists time.Time) {
+	f, err := os.Create(path)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	defer f.Close()
 
-	// artists must go first
-	if (config.Session.Flags & SessionFlags_ScanArtists) != 0 {
-		f.WriteString(c.Session.CurrentDateTime.Format(SQUE_DATE_FORMAT))
-	} else {
-		f.WriteString(c.Session.LastRunArtists.Format(SQUE_DATE_FORMAT))
-	}
-
-	// delimeter
+	// artists must go first, playlists second
+	f.WriteString(lastRunArtists.Format(SQUE_DATE_FORMAT))
 	f.WriteString(",")
+	f.WriteString(lastRunPlaylists.Format(SQUE_DATE_FORMAT))
+}
 
-	// playlists must go second
-	if (config.Session.Flags & SessionFlags_ScanPlaylists) != 0 {
-		f.WriteString(c.Session.CurrentDateTime.Format(SQUE_DATE_FORMAT))
-	} else {
-		f.WriteString(c.Session.LastRunPlaylists.Format(SQUE_DATE_FORMAT))
+// NextLastRunDates returns the artist and playlist dates a run saves,
+// today for the scanned categories and the previous date for the others.
+func NextLastRunDates(c *ConfigData) (time.Time, time.Time) {
+	lastRunArtists := c.Session.LastRunArtists
+	if (c.Session.Flags & SessionFlags_ScanArtists) != 0 {
+		lastRunArtists = c.Session.CurrentDateTime
 	}
+
+	lastRunPlaylists := c.Session.LastRunPlaylists
+	if (c.Session.Flags & SessionFlags_ScanPlaylists) != 0 {
+		lastRunPlaylists = c.Session.CurrentDateTime
+	}
+
+	return lastRunArtists, lastRunPlaylists
 }
 
 // ---------------------------------------------------------
@@ -241,38 +360,14 @@ func parseLastRunFile(path string) (string, string) {
 
 // ---------------------------------------------------------
 // ---------------------------------------------------------
-func CheckOption(config *ConfigData, argv []string, index int) {
-	if argv[index] == "-a" { // Scan Artists
-		config.Session.Flags |= SessionFlags_ScanArtists
-	} else if argv[index] == "-p" { // Scan Playlists
-		config.Session.Flags |= SessionFlags_ScanPlaylists
-	} else if argv[index] == "-fp" { // Print Followed Playlists
-		config.Session.Flags |= SessionFlags_PrintFollowedPlaylists
-	} else if argv[index] == "-d" {
-		storeArtist := false
-		storePlaylist := false
-		for i := 1; i < len(argv); i++ {
-			if argv[i] == "-a" {
-				storeArtist = true
-			}
-			if argv[i] == "-p" {
-				storePlaylist = true
-			}
-		}
-
-		dateTime, timeErr := time.Parse(SQUE_DATE_FORMAT, argv[index+1])
-		if timeErr != nil {
-			log.Fatalf("Could not parse debug date: %s\n", timeErr)
-		}
-
-		if storeArtist {
-			fmt.Printf("Overwriting last run artist date %s. Writing new artist date %s.", config.Session.LastRunArtists, dateTime)
-			config.Session.LastRunArtists = dateTime
-		}
-		if storePlaylist {
-			fmt.Printf("Overwriting last run playlist date %s. Writing new playlist date %s.", config.Session.LastRunPlaylists, dateTime)
-			config.Session.LastRunPlaylists = dateTime
-		}
+func OverrideLastRunDate(config *ConfigData, dateTime time.Time) {
+	if (config.Session.Flags & SessionFlags_ScanArtists) != 0 {
+		fmt.Printf("Overwriting last run artist date %s. Writing new artist date %s.\n", config.Session.LastRunArtists, dateTime)
+		config.Session.LastRunArtists = dateTime
+	}
+	if (config.Session.Flags & SessionFlags_ScanPlaylists) != 0 {
+		fmt.Printf("Overwriting last run playlist date %s. Writing new playlist date %s.\n", config.Session.LastRunPlaylists, dateTime)
+		config.Session.LastRunPlaylists = dateTime
 	}
 }
 
@@ -317,7 +412,9 @@ func initPlaylistDates(c *ConfigData) {
 
 // ---------------------------------------------------------
 // ---------------------------------------------------------
-func AlertStalePlaylistsAndSavePlaylistUpdates(c *ConfigData, cache *Cache) {
+// AlertStalePlaylists works out when every scanned playlist last had a track
+// added and lists the ones that haven't changed in a long time.
+func AlertStalePlaylists(c *ConfigData, cache *Cache) {
 	initPlaylistDates(c)
 
 	staleCount := 0
@@ -355,26 +452,44 @@ func AlertStalePlaylistsAndSavePlaylistUpdates(c *ConfigData, cache *Cache) {
 	}
 
 	fmt.Printf("Found %d possible stale playlists.\n", staleCount)
+}
 
-	if len(c.User.PlaylistMetaPath) > 0 {
-		f, err := os.Create(c.User.PlaylistMetaPath)
-		if err != nil {
-			log.Fatal(err)
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func GetPlaylistUpdates(c *ConfigData, cache *Cache) []PlaylistUpdate {
+	var updates []PlaylistUpdate
+
+	for _, playlistMeta := range c.Playlists {
+		playlistDataIndex, ok := cache.PlaylistDatasMap[playlistMeta.ID]
+		if !ok {
+			msg := fmt.Sprintf("Playlist should exist in map: AlertStale Write %s %s", playlistMeta.Name, playlistMeta.ID)
+			log.Fatal(msg)
 		}
 
-		defer f.Close()
+		playlistData := cache.PlaylistDatas[playlistDataIndex]
 
-		for _, playlistMeta := range config.Playlists {
-			playlistDataIndex, ok := cache.PlaylistDatasMap[playlistMeta.ID]
-			if !ok {
-				msg := fmt.Sprintf("Playlist should exist in map: AlertStale Write %s %s", playlistMeta.Name, playlistMeta.ID)
-				log.Fatal(msg)
-			}
+		updates = append(updates, PlaylistUpdate{
+			ID:          playlistData.ID,
+			Name:        playlistData.Name,
+			LastUpdated: playlistData.LastUpdated,
+		})
+	}
 
-			playlistData := cache.PlaylistDatas[playlistDataIndex]
+	return updates
+}
 
-			f.WriteString(fmt.Sprintf("%s,%s\n", playlistData.ID, playlistData.LastUpdated.Format(time.UnixDate)))
-		}
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func SavePlaylistUpdates(path string, updates []PlaylistUpdate) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer f.Close()
+
+	for _, update := range updates {
+		f.WriteString(fmt.Sprintf("%s,%s\n", update.ID, update.LastUpdated.Format(time.UnixDate)))
 	}
 }
 
@@ -400,6 +515,154 @@ func ParseRawURI(uri *string, outUri *string) bool {
 	return true
 }
 
+// DescribeTrack names a queued track and where it came from, for reports.
+func DescribeTrack(cache *Cache, trackDataIndex int) string {
+	trackData := cache.TrackDatas[trackDataIndex]
+
+	if trackData.Artist >= 0 {
+		artistData := cache.ArtistDatas[trackData.Artist]
+		albumData := cache.AlbumDatas[trackData.Album]
+		return fmt.Sprintf("%s --- %s --- %s", artistData.Name, albumData.Name, trackData.Name)
+	}
+
+	if trackData.Playlist >= 0 {
+		playlistData := cache.PlaylistDatas[trackData.Playlist]
+		return fmt.Sprintf("%s --- %s --- %s (from %s)", trackData.Credits(), trackData.Name, trackData.DateTime.Format(SQUE_DATE_FORMAT), playlistData.Name)
+	}
+
+	return trackData.Name
+}
+
+// ParseAlbumType reads the album_type Spotify reports.
+func ParseAlbumType(albumType string) AlbumType {
+	if albumType == "single" {
+		return AlbumType_Single
+	} else if albumType == "compilation" {
+		return AlbumType_Compilation
+	}
+	return AlbumType_Album // assume "album"
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (t AlbumType) String() string {
+	switch t {
+	case AlbumType_Compilation:
+		return "compilation"
+	case AlbumType_Single:
+		return "single"
+	case AlbumType_AppearsOn:
+		return "appears_on"
+	}
+	return "album"
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func CreditedArtists(artists []spotify.SimpleArtist) string {
+	names := make([]string, len(artists))
+	for i, artist := range artists {
+		names[i] = artist.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// ParseCredits keeps every artist credited on a track, in credit order.
+// They are linked to followed artists by LinkCredits once scanning is done.
+func ParseCredits(artists []spotify.SimpleArtist) []CreditedArtist {
+	credits := make([]CreditedArtist, len(artists))
+	for i, artist := range artists {
+		credits[i] = CreditedArtist{ID: artist.ID.String(), Name: artist.Name, Artist: -1}
+	}
+	return credits
+}
+
+// Credits is the names of the artists credited on the track.
+func (t *Track) Credits() string {
+	names := make([]string, len(t.Artists))
+	for i, artist := range t.Artists {
+		names[i] = artist.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// FollowedArtists is the followed artists credited on the track.
+func (t *Track) FollowedArtists() []int {
+	var followed []int
+	for _, artist := range t.Artists {
+		if artist.Artist >= 0 {
+			followed = append(followed, artist.Artist)
+		}
+	}
+	return followed
+}
+
+// LoadFollowedArtists adds every followed artist to the cache without
+// scanning them, so LinkCredits knows them when only playlists are scanned.
+func LoadFollowedArtists(client *spotify.Client, cache *Cache) error {
+	artists, err := client.CurrentUsersFollowedArtists(context.Background(), spotify.Limit(SQUE_SPOTIFY_LIMIT_ARTISTS))
+
+	for err == nil && len(artists.Artists) > 0 {
+		for _, artist := range artists.Artists {
+			if _, ok := cache.ArtistDatasMap[artist.ID.String()]; ok {
+				continue
+			}
+			cache.ArtistDatasMap[artist.ID.String()] = len(cache.ArtistDatas)
+			cache.ArtistDatas = append(cache.ArtistDatas,
+				Artist{
+					ID:   artist.ID.String(),
+					Name: artist.Name,
+				})
+		}
+
+		if len(artists.Cursor.After) == 0 {
+			break
+		}
+		artists, err = client.CurrentUsersFollowedArtists(context.Background(), spotify.Limit(SQUE_SPOTIFY_LIMIT_ARTISTS), spotify.After(artists.Cursor.After))
+	}
+
+	return err
+}
+
+// LinkCredits links the credited artists of every track to the followed
+// artists the scan found, so collaborations between followed artists are
+// known whichever artist the track was found under. The other followed
+// artists of queued tracks are added to why they were queued.
+func LinkCredits(cache *Cache) {
+	for trackDataIndex := range cache.TrackDatas {
+		trackData := &cache.TrackDatas[trackDataIndex]
+
+		var others []string
+		for i, artist := range trackData.Artists {
+			artistDataIndex, ok := cache.ArtistDatasMap[artist.ID]
+			if !ok {
+				continue
+			}
+			trackData.Artists[i].Artist = artistDataIndex
+			if artistDataIndex != trackData.Artist {
+				others = append(others, artist.Name)
+			}
+		}
+
+		if len(others) > 0 && len(trackData.Reason) > 0 {
+			trackData.Reason += fmt.Sprintf(", credits followed %s", strings.Join(others, ", "))
+		}
+	}
+}
+
+// TrackSource names the followed artist or scanned playlist a track came from.
+func TrackSource(cache *Cache, trackDataIndex int) string {
+	trackData := cache.TrackDatas[trackDataIndex]
+
+	if trackData.Artist >= 0 {
+		return fmt.Sprintf("artist %s", cache.ArtistDatas[trackData.Artist].Name)
+	}
+	if trackData.Playlist >= 0 {
+		return fmt.Sprintf("playlist %s", cache.PlaylistDatas[trackData.Playlist].Name)
+	}
+	return ""
+}
+
 // ---------------------------------------------------------
 // ---------------------------------------------------------
 func ScanArtistTracks(client *spotify.Client, cache *Cache, config *ConfigData, adder *TrackAdder) {
@@ -462,20 +725,13 @@ func ScanArtistTracks(client *spotify.Client, cache *Cache, config *ConfigData,
 
 					// Album data does not exist, create it
 					if !ok {
-						albumType := AlbumType_Album // assume "album"
-						if album.AlbumType == "single" {
-							albumType = AlbumType_Single
-						} else if album.AlbumType == "compilation" {
-							albumType = AlbumType_Compilation
-						}
-
 						albumDataIndex = len(cache.AlbumDatas)
 						cache.AlbumDatasMap[album.ID.String()] = albumDataIndex
 						cache.AlbumDatas = append(cache.AlbumDatas,
 							Album{
 								ID:          album.ID.String(),
 								Name:        album.Name,
-								Type:        albumType,
+								Type:        ParseAlbumType(album.AlbumType),
 								Artist:      artistDataIndex,
 								ReleaseDate: albumReleaseDateTime,
 							})
@@ -512,6 +768,9 @@ func ScanArtistTracks(client *spotify.Client, cache *Cache, config *ConfigData,
 									Playlist: -1, // not from a playlist
 									Score:    0,  // dont care about score of artists we follow, we want em all
 									DateTime: albumReleaseDateTime,
+									Duration: track.Duration,
+									Artists:  ParseCredits(track.Artists),
+									Title:    ParseTitle(track.Name),
 								})
 							albumData.Tracks = append(albumData.Tracks, trackDataIndex)
 
@@ -570,13 +829,44 @@ func ScanArtistTracks(client *spotify.Client, cache *Cache, config *ConfigData,
 					// Not that it matters, we want the song anyway... but grab the score
 					trackData.Score = track.Popularity
 
+					if track.LinkedFrom != nil {
+						cache.TrackDatas[trackDataIndex].LinkedURI = string(track.LinkedFrom.URI)
+					}
+					cache.TrackDatas[trackDataIndex].ISRC = track.ExternalIDs["isrc"]
+
+					// Never queue a track an earlier run queued, unless it is the album
+					// version of a queued single and singles are replaced
+					entry, queued := cache.Ledger.Lookup(track.ExternalIDs["isrc"], trackData.URI, cache.TrackDatas[trackDataIndex].LinkedURI)
+					albumVersion := queued && albumData.Type == AlbumType_Album && entry.Release == AlbumType_Single.String()
+					if albumVersion && !config.User.ReplaceSingles {
+						fmt.Printf("  -%s, album version of the single %s\n", trackData.Name, describeLedgerEntry(entry))
+						logger.SingleMessages = append(logger.SingleMessages, fmt.Sprintf("%s --- %s --- %s --- skipped, the single was %s\n", artistData.Name, albumData.Name, trackData.Name, describeLedgerEntry(entry)))
+						continue
+					}
+					if queued && !albumVersion {
+						fmt.Printf("  -%s, already %s\n", trackData.Name, describeLedgerEntry(entry))
+						continue
+					}
+
 					if *track.IsPlayable {
 						// The track is playable and can be added
+						reason := fmt.Sprintf("released %s", albumData.ReleaseDate.Format(SQUE_DATE_FORMAT))
+						destination := &adder.ListenLater
 						if track.Duration >= 1860000 {
-							adder.Sets = append(adder.Sets, trackDataIndex)
-						} else {
-							adder.ListenLater = append(adder.ListenLater, trackDataIndex)
+							reason += ", over 31 minutes"
+							destination = &adder.Sets
+						}
+
+						// The album version goes where the single went, in its place
+						if albumVersion {
+							reason += fmt.Sprintf(", replaces the single %s", describeLedgerEntry(entry))
+							destination = adder.Destination(entry.Destination)
+							cache.TrackDatas[trackDataIndex].Replaces = entry.URI
+							logger.SingleMessages = append(logger.SingleMessages, fmt.Sprintf("%s --- %s --- %s --- replaces the single %s\n", artistData.Name, albumData.Name, trackData.Name, describeLedgerEntry(entry)))
 						}
+
+						*destination = append(*destination, trackDataIndex)
+						cache.TrackDatas[trackDataIndex].Reason = reason
 						fmt.Printf("  *%s\n", trackData.Name)
 						logger.ArtistMessages = append(logger.ArtistMessages, fmt.Sprintf("%s --- %s --- %s --- %s --- %d --- %v\n", artistData.Name, albumData.Name, albumData.ReleaseDate.String(), trackData.Name, trackData.Score, track.AvailableMarkets))
 					} else {
@@ -648,6 +938,22 @@ func ScanPlaylistTracks(client *spotify.Client, cache *Cache, config *ConfigData
 				trackDataIndex, ok := cache.TrackDatasMap[playlistTrack.Track.ID.String()]
 
 				if !ok {
+					// Keep the album for reviews, playlist albums don't belong to a followed artist
+					album := playlistTrack.Track.Album
+					albumDataIndex, ok := cache.AlbumDatasMap[album.ID.String()]
+					if !ok {
+						albumDataIndex = len(cache.AlbumDatas)
+						cache.AlbumDatasMap[album.ID.String()] = albumDataIndex
+						cache.AlbumDatas = append(cache.AlbumDatas,
+							Album{
+								ID:          album.ID.String(),
+								Name:        album.Name,
+								Type:        ParseAlbumType(album.AlbumType),
+								Artist:      -1,
+								ReleaseDate: album.ReleaseDateTime(),
+							})
+					}
+
 					trackDataIndex = len(cache.TrackDatas)
 					cache.TrackDatasMap[playlistTrack.Track.ID.String()] = trackDataIndex
 					cache.TrackDatas = append(cache.TrackDatas,
@@ -655,15 +961,31 @@ func ScanPlaylistTracks(client *spotify.Client, cache *Cache, config *ConfigData
 							URI:      string(playlistTrack.Track.URI),
 							Name:     playlistTrack.Track.Name,
 							Artist:   -1,
-							Album:    -1,
+							Album:    albumDataIndex,
 							Playlist: playlistDataIndex,
 							Score:    playlistTrack.Track.Popularity,
 							DateTime: trackReleaseDateTime,
+							Duration: playlistTrack.Track.Duration,
+							Artists:  ParseCredits(playlistTrack.Track.Artists),
+							ISRC:     playlistTrack.Track.ExternalIDs["isrc"],
+							Title:    ParseTitle(playlistTrack.Track.Name),
 						})
+					cache.AlbumDatas[albumDataIndex].Tracks = append(cache.AlbumDatas[albumDataIndex].Tracks, trackDataIndex)
+
+					if playlistTrack.Track.LinkedFrom != nil {
+						cache.TrackDatas[trackDataIndex].LinkedURI = string(playlistTrack.Track.LinkedFrom.URI)
+					}
 				}
 
 				playlistData.Tracks = append(playlistData.Tracks, trackDataIndex)
 
+				// Never queue a track an earlier run queued
+				trackData := cache.TrackDatas[trackDataIndex]
+				if entry, ok := cache.Ledger.Lookup(trackData.ISRC, trackData.URI, trackData.LinkedURI); ok {
+					fmt.Printf("  -%s, already %s\n", trackData.Name, describeLedgerEntry(entry))
+					continue
+				}
+
 				sortedPlaylistTracks = append(sortedPlaylistTracks, trackDataIndex)
 			}
 
@@ -692,7 +1014,9 @@ func ScanPlaylistTracks(client *spotify.Client, cache *Cache, config *ConfigData
 			trackData := cache.TrackDatas[trackDataIndex]
 			fmt.Printf("  *%s\n", trackData.Name)
 
-			logger.PlaylistMessages = append(logger.PlaylistMessages, fmt.Sprintf("%s --- %s --- %s --- %d\n", playlistData.Name, trackData.DateTime, trackData.Name, trackData.Score))
+			cache.TrackDatas[trackDataIndex].Reason = fmt.Sprintf("added to %s %s, popularity %d", playlistData.Name, trackData.DateTime.Format(SQUE_DATE_FORMAT), trackData.Score)
+
+			logger.PlaylistMessages = append(logger.PlaylistMessages, fmt.Sprintf("%s --- %s --- %s --- %s --- %d\n", playlistData.Name, trackData.DateTime, trackData.Credits(), trackData.Name, trackData.Score))
 
 			adder.ListenLater = append(adder.ListenLater, trackDataIndex)
 		}
@@ -701,22 +1025,28 @@ func ScanPlaylistTracks(client *spotify.Client, cache *Cache, config *ConfigData
 
 // ---------------------------------------------------------
 // ---------------------------------------------------------
-func AddTracksToPlaylist(client *spotify.Client, cache *Cache, playlistId string, tracks []int, shuffle bool) {
-	if shuffle {
-		for i := range tracks {
-			j := rand.Intn(i + 1)
-			tracks[i], tracks[j] = tracks[j], tracks[i]
-		}
-	}
+func AddTracksToPlaylist(client *spotify.Client, playlistId string, uris []string) PlaylistAddition {
+	addition := PlaylistAddition{PlaylistID: playlistId}
 
-	totalTracks := len(tracks)
+	totalTracks := len(uris)
 
 	if totalTracks == 0 {
-		return
+		return addition
 	}
 
 	spotPlaylistID := spotify.ID(playlistId)
 
+	// Tracks are appended, so they start at the current end of the playlist.
+	// Without it they are still added, undo just can't tell them from copies
+	// the playlist already had
+	position := -1
+	playlist, err := client.GetPlaylist(context.Background(), spotPlaylistID, spotify.Fields("tracks.total"))
+	if err != nil {
+		fmt.Printf("  !Could not read the length of %s, undo will remove every copy of the added tracks: %s\n", playlistId, describeSpotifyError(err))
+	} else {
+		position = playlist.Tracks.Total
+	}
+
 	trackIndex := 0
 
 	for trackIndex < totalTracks {
@@ -726,48 +1056,43 @@ func AddTracksToPlaylist(client *spotify.Client, cache *Cache, playlistId string
 			chunkLength = totalTracks - trackIndex
 		}
 
-		trackchunk := make([]spotify.ID, chunkLength)
-		subtracks := tracks[trackIndex : trackIndex+chunkLength]
+		var trackchunk []spotify.ID
+		subtracks := uris[trackIndex : trackIndex+chunkLength]
 
-		for trackDataIndex, trackDataID := range subtracks {
-			trackData := cache.TrackDatas[trackDataID]
+		for _, uri := range subtracks {
 			var spotId string
-			if ParseRawURI(&trackData.URI, &spotId) {
-				trackchunk[trackDataIndex] = spotify.ID(spotId)
+			if ParseRawURI(&uri, &spotId) {
+				trackchunk = append(trackchunk, spotify.ID(spotId))
 			}
-
-			/*if strings.Contains(trackData.URI, "spotify:track:") {
-				spotId := spotify.ID(trackData.URI[len("spotify:track:"):len(trackData.URI)])
-				if len(spotId.String()) == 22 {
-					trackchunk[trackDataIndex] = spotId
-				} else {
-					fmt.Printf("TODO: BAD SUBSTR TRACK ID [%s]\n", spotId.String())
-				}
-			} else {
-				spotId := spotify.ID(trackData.URI)
-				if len(spotId.String()) == 22 {
-					trackchunk[trackDataIndex] = spotId
-				} else {
-					fmt.Printf("TODO: BAD TRACK ID [%s]\n", spotId.String())
-				}
-			}*/
 		}
 
-		_, err := client.AddTracksToPlaylist(context.Background(), spotPlaylistID, trackchunk...)
+		snapshotID, err := client.AddTracksToPlaylist(context.Background(), spotPlaylistID, trackchunk...)
 		if err != nil {
 			fmt.Println(err)
 			for tdi, tdid := range trackchunk {
 				fmt.Printf("Index %d, ID %s\n", tdi, tdid.String())
 			}
+		} else {
+			for _, spotId := range trackchunk {
+				addition.Tracks = append(addition.Tracks, AddedTrack{URI: "spotify:track:" + spotId.String(), Position: position})
+				if position >= 0 {
+					position++
+				}
+			}
+			addition.SnapshotID = snapshotID
 		}
 
 		trackIndex += chunkLength
 	}
+
+	return addition
 }
 
 // ---------------------------------------------------------
 // ---------------------------------------------------------
-func ShowFollowedPlaylists(client *spotify.Client, config *ConfigData) {
+func GetFollowedPlaylists(client *spotify.Client, config *ConfigData) []spotify.SimplePlaylist {
+	var playlists []spotify.SimplePlaylist
+
 	playlistPage, err := client.GetPlaylistsForUser(context.Background(), config.User.UserID)
 
 	if err != nil {
@@ -777,19 +1102,37 @@ func ShowFollowedPlaylists(client *spotify.Client, config *ConfigData) {
 	scanPlaylist := true
 
 	for len(playlistPage.Playlists) > 0 && scanPlaylist {
-
-		for i := 0; i < len(playlistPage.Playlists); i++ {
-			playlist := playlistPage.Playlists[i]
-			fmt.Printf("%s -- %s\n", playlist.ID, playlist.Name)
-		}
+		playlists = append(playlists, playlistPage.Playlists...)
 
 		playlistErr := client.NextPage(context.Background(), playlistPage)
 		scanPlaylist = playlistErr == nil
 	}
+
+	return playlists
+}
+
+// GetAllPlaylistTracks fetches every page of a playlist. With the market set
+// relinked tracks report the track they were linked from.
+func GetAllPlaylistTracks(client *spotify.Client, playlistId string) ([]spotify.PlaylistTrack, error) {
+	var tracks []spotify.PlaylistTrack
+
+	playlistTracks, err := client.GetPlaylistTracks(context.Background(), spotify.ID(playlistId), spotify.Limit(SQUE_SPOTIFY_LIMIT_PLAYLISTS), spotify.Market(SQUE_SPOTIFY_MARKET))
+
+	for err == nil {
+		tracks = append(tracks, playlistTracks.Tracks...)
+		err = client.NextPage(context.Background(), playlistTracks)
+	}
+
+	if err != spotify.ErrNoMorePages {
+		return nil, err
+	}
+	return tracks, nil
 }
 
 // ---------------------------------------------------------
 // ---------------------------------------------------------
-func CullDuplicateTracks(cache *Cache) {
-	fmt.Println("TODO CULL DUPS")
+func ShowFollowedPlaylists(client *spotify.Client, config *ConfigData) {
+	for _, playlist := range GetFollowedPlaylists(client, config) {
+		fmt.Printf("%s -- %s\n", playlist.ID, playlist.Name)
+	}
 }
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+const SQUE_RUNS_FILE = "user.runs" // default run records
+const SQUE_RUNS_KEPT = 20          // run records kept for undo, oldest dropped first
+
+// ---------------------------------------------------------
+// Run Types
+// ---------------------------------------------------------
+
+type AddedTrack struct {
+	URI      string `json:"uri"`
+	Position int    `json:"position"` // in the playlist right after the run, -1 if unknown
+}
+
+// PlaylistAddition is what one run added to one destination playlist. Only
+// the snapshot of the last chunk added is kept: chunks are appended, so the
+// positions of earlier chunks don't move and that snapshot holds every
+// track at the position recorded for it.
+type PlaylistAddition struct {
+	Name       string       `json:"name"`
+	PlaylistID string       `json:"playlist_id"`
+	SnapshotID string       `json:"snapshot_id"` // returned by the last successful add, covers every chunk
+	Tracks     []AddedTrack `json:"tracks"`
+}
+
+// PlaylistRemoval is what one run took out of one destination playlist.
+type PlaylistRemoval struct {
+	Name       string   `json:"name"`
+	PlaylistID string   `json:"playlist_id"`
+	URIs       []string `json:"uris"`
+}
+
+// RunRecord is everything a run changed, so undo can take it back.
+type RunRecord struct {
+	AppliedAt        time.Time          `json:"applied_at"`
+	UndoneAt         *time.Time         `json:"undone_at,omitempty"`
+	LastRunFile      string             `json:"last_run_file"`                // contents before the run
+	PlaylistMetaFile *string            `json:"playlist_meta_file,omitempty"` // contents before the run, if it saved playlist updates
+	Additions        []PlaylistAddition `json:"additions"`
+	Removals         []PlaylistRemoval  `json:"removals,omitempty"` // singles replaced by their album version
+}
+
+// ---------------------------------------------------------
+// Run Records
+// ---------------------------------------------------------
+
+func LoadRunRecords(path string) []RunRecord {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var runs []RunRecord
+	if err := json.Unmarshal(data, &runs); err != nil {
+		log.Fatalf("Could not parse run records %s: %s\n", path, err)
+	}
+	return runs
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func SaveRunRecords(path string, runs []RunRecord) {
+	if len(runs) > SQUE_RUNS_KEPT {
+		runs = runs[len(runs)-SQUE_RUNS_KEPT:]
+	}
+
+	data, err := json.MarshalIndent(runs, "", "    ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func RecordRun(c *ConfigData, run RunRecord) {
+	SaveRunRecords(c.User.RunsPath, append(LoadRunRecords(c.User.RunsPath), run))
+}
+
+// ---------------------------------------------------------
+// Undo
+// ---------------------------------------------------------
+
+// LastUndoableRun is the index of the last run that wasn't undone, or -1.
+func LastUndoableRun(runs []RunRecord) int {
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].UndoneAt == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeAddition takes the tracks of one addition back out of its playlist.
+// Positions are relative to the snapshot the run left behind, so tracks moved
+// or added since are not touched. Tracks added at an unknown position are
+// removed with every copy of them.
+func removeAddition(client *spotify.Client, addition PlaylistAddition) error {
+	var tracks []AddedTrack
+	var anywhere []spotify.ID
+	for _, track := range addition.Tracks {
+		var spotId string
+		if track.Position < 0 {
+			if ParseRawURI(&track.URI, &spotId) {
+				anywhere = append(anywhere, spotify.ID(spotId))
+			}
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+
+	// Remove from the end so earlier positions stay put between requests
+	sort.Slice(tracks, func(i, j int) bool {
+		return tracks[i].Position > tracks[j].Position
+	})
+
+	for start := 0; start < len(tracks); start += SQUE_SPOTIFY_LIMIT_PLAYLISTS {
+		end := start + SQUE_SPOTIFY_LIMIT_PLAYLISTS
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+
+		var toRemove []spotify.TrackToRemove
+		for _, track := range tracks[start:end] {
+			var spotId string
+			if ParseRawURI(&track.URI, &spotId) {
+				toRemove = append(toRemove, spotify.NewTrackToRemove(spotId, []int{track.Position}))
+			}
+		}
+
+		_, err := client.RemoveTracksFromPlaylistOpt(context.Background(), spotify.ID(addition.PlaylistID), toRemove, addition.SnapshotID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(anywhere); start += SQUE_SPOTIFY_LIMIT_PLAYLISTS {
+		end := start + SQUE_SPOTIFY_LIMIT_PLAYLISTS
+		if end > len(anywhere) {
+			end = len(anywhere)
+		}
+
+		if _, err := client.RemoveTracksFromPlaylist(context.Background(), spotify.ID(addition.PlaylistID), anywhere[start:end]...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UndoLastRun removes the tracks the last run that wasn't undone added and
+// restores the dates it saved.
+func UndoLastRun(client *spotify.Client, c *ConfigData, confirmed bool) {
+	runs := LoadRunRecords(c.User.RunsPath)
+
+	last := LastUndoableRun(runs)
+	if last < 0 {
+		fmt.Println("Nothing to undo.")
+		return
+	}
+	run := &runs[last]
+
+	fmt.Printf("Run of %s:\n", run.AppliedAt.Format(time.RFC1123))
+	for _, addition := range run.Additions {
+		fmt.Printf("  *Remove %d tracks from %s (%s)\n", len(addition.Tracks), addition.Name, addition.PlaylistID)
+	}
+	for _, removal := range run.Removals {
+		fmt.Printf("  *Add back %d replaced singles to %s (%s)\n", len(removal.URIs), removal.Name, removal.PlaylistID)
+	}
+	fmt.Printf("  *Restore last run dates %s\n", run.LastRunFile)
+	if run.PlaylistMetaFile != nil {
+		fmt.Printf("  *Restore playlist updates in %s\n", c.User.PlaylistMetaPath)
+	}
+	fmt.Printf("  *Forget the tracks it queued in %s\n", c.User.LedgerPath)
+
+	if !confirmed && !promptYesNo("Undo this run?", false) {
+		fmt.Println("Nothing was undone.")
+		return
+	}
+
+	for _, addition := range run.Additions {
+		fmt.Printf("Removing %d tracks from %s....\n", len(addition.Tracks), addition.Name)
+		if err := removeAddition(client, addition); err != nil {
+			log.Fatalf("Could not remove the tracks from %s: %s\n", addition.Name, describeSpotifyError(err))
+		}
+	}
+
+	// Replaced singles go back at the end of their playlist
+	for _, removal := range run.Removals {
+		fmt.Printf("Adding back %d singles to %s....\n", len(removal.URIs), removal.Name)
+		if added := AddTracksToPlaylist(client, removal.PlaylistID, removal.URIs); len(added.Tracks) < len(removal.URIs) {
+			fmt.Printf("  !Could not add every single back to %s, add these by hand: %s\n", removal.Name, strings.Join(removal.URIs, " "))
+		}
+	}
+
+	if err := ioutil.WriteFile(c.User.LastRunPath, []byte(run.LastRunFile), 0644); err != nil {
+		log.Fatal(err)
+	}
+	if run.PlaylistMetaFile != nil && len(c.User.PlaylistMetaPath) > 0 {
+		if err := ioutil.WriteFile(c.User.PlaylistMetaPath, []byte(*run.PlaylistMetaFile), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Let the tracks be queued again
+	LoadLedger(c.User.LedgerPath).RemoveRun(run.AppliedAt)
+
+	undoneAt := time.Now()
+	run.UndoneAt = &undoneAt
+	SaveRunRecords(c.User.RunsPath, runs)
+
+	fmt.Println("----------------------------------------------")
+	fmt.Println("Undone!")
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func readFileOrEmpty(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ---------------------------------------------------------
+// Constants
+// ---------------------------------------------------------
+
+// user keys that must be set, in the file or the environment
+var configRequiredUserKeys = []string{"client_id", "redirect_uri", "logs_path", "last_run_path", "listen_later"}
+
+// user keys holding a Spotify playlist ID
+var configPlaylistUserKeys = []string{"listen_later", "compilation", "sets"}
+
+var spotifyIDPattern = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+// ---------------------------------------------------------
+// Validation Types
+// ---------------------------------------------------------
+
+// ConfigProblem is one thing wrong with a config file. Line is 0 when the
+// problem can't be pinned to a line. Notes are only wrong for some ways of
+// running and don't fail validation.
+type ConfigProblem struct {
+	Line    int
+	Path    string
+	Message string
+	Note    bool
+}
+
+func (p ConfigProblem) String() string {
+	if len(p.Path) > 0 {
+		return fmt.Sprintf("%s: %s", p.Path, p.Message)
+	}
+	return p.Message
+}
+
+// configNode is a parsed config value that remembers the line it came from,
+// so problems can point back into the file whatever its format.
+type configNode struct {
+	Kind   string // object, array, string, integer, number, boolean, datetime, null
+	Value  interface{}
+	Line   int
+	Keys   []string
+	Fields map[string]*configNode
+	Items  []*configNode
+}
+
+type configValidator struct {
+	problems []ConfigProblem
+	lines    map[string]int
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (v *configValidator) report(path string, format string, args ...interface{}) {
+	v.problems = append(v.problems, ConfigProblem{
+		Line:    v.lines[path],
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (v *configValidator) note(path string, format string, args ...interface{}) {
+	v.report(path, format, args...)
+	v.problems[len(v.problems)-1].Note = true
+}
+
+// reported tells whether a path already has a problem, so checks of the
+// decoded values don't repeat what checkNode found.
+func (v *configValidator) reported(path string) bool {
+	for _, problem := range v.problems {
+		if problem.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// CountConfigErrors is the number of problems that aren't notes.
+func CountConfigErrors(problems []ConfigProblem) int {
+	count := 0
+	for _, problem := range problems {
+		if !problem.Note {
+			count++
+		}
+	}
+	return count
+}
+
+// ---------------------------------------------------------
+// JSON
+// ---------------------------------------------------------
+
+func lineAtOffset(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func parseJSONNode(data []byte) (*configNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	node, err := decodeJSONNode(dec, data)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%d: %s", lineAtOffset(data, syntaxErr.Offset), syntaxErr)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%d: unexpected end of file", lineAtOffset(data, int64(len(data))))
+		}
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func decodeJSONNode(dec *json.Decoder, data []byte) (*configNode, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	node := &configNode{Line: lineAtOffset(data, dec.InputOffset())}
+
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '{' {
+			node.Kind = "object"
+			node.Fields = map[string]*configNode{}
+			for dec.More() {
+				keyToken, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := keyToken.(string)
+				keyLine := lineAtOffset(data, dec.InputOffset())
+
+				child, err := decodeJSONNode(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				child.Line = keyLine
+
+				node.Keys = append(node.Keys, key)
+				node.Fields[key] = child
+			}
+		} else {
+			node.Kind = "array"
+			for dec.More() {
+				child, err := decodeJSONNode(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				node.Items = append(node.Items, child)
+			}
+		}
+		// closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	case json.Number:
+		node.Value = value.String()
+		if strings.ContainsAny(value.String(), ".eE") {
+			node.Kind = "number"
+		} else {
+			node.Kind = "integer"
+		}
+	case string:
+		node.Kind = "string"
+		node.Value = value
+	case bool:
+		node.Kind = "boolean"
+		node.Value = value
+	default:
+		node.Kind = "null"
+	}
+
+	return node, nil
+}
+
+// ---------------------------------------------------------
+// YAML
+// ---------------------------------------------------------
+
+func parseYAMLNode(data []byte) (*configNode, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, errors.New("the file is empty")
+	}
+	return convertYAMLNode(doc.Content[0]), nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func convertYAMLNode(n *yaml.Node) *configNode {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	node := &configNode{Line: n.Line}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		node.Kind = "object"
+		node.Fields = map[string]*configNode{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			child := convertYAMLNode(n.Content[i+1])
+			child.Line = n.Content[i].Line
+			node.Keys = append(node.Keys, key)
+			node.Fields[key] = child
+		}
+	case yaml.SequenceNode:
+		node.Kind = "array"
+		for _, item := range n.Content {
+			node.Items = append(node.Items, convertYAMLNode(item))
+		}
+	default:
+		node.Value = n.Value
+		switch n.ShortTag() {
+		case "!!int":
+			node.Kind = "integer"
+		case "!!float":
+			node.Kind = "number"
+		case "!!bool":
+			node.Kind = "boolean"
+		case "!!null":
+			node.Kind = "null"
+		case "!!timestamp":
+			node.Kind = "datetime"
+		default:
+			node.Kind = "string"
+		}
+	}
+
+	return node
+}
+
+// ---------------------------------------------------------
+// TOML
+// ---------------------------------------------------------
+
+var tomlHeaderPattern = regexp.MustCompile(`^\s*(\[\[?)\s*([^\]]+?)\s*\]\]?`)
+var tomlKeyPattern = regexp.MustCompile(`^\s*("[^"]*"|[A-Za-z0-9_-]+)\s*=`)
+
+// tomlKeyLines finds the line of every table and key, keyed by the same path
+// convertTOMLNode builds, since the TOML decoder doesn't report positions.
+func tomlKeyLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	arrayCounts := map[string]int{}
+	table := ""
+
+	for i, line := range strings.Split(string(data), "\n") {
+		if m := tomlHeaderPattern.FindStringSubmatch(line); m != nil {
+			table = m[2]
+			if m[1] == "[[" {
+				table = fmt.Sprintf("%s[%d]", m[2], arrayCounts[m[2]])
+				arrayCounts[m[2]]++
+			}
+			lines[table] = i + 1
+		} else if m := tomlKeyPattern.FindStringSubmatch(line); m != nil {
+			lines[joinConfigPath(table, strings.Trim(m[1], `"`))] = i + 1
+		}
+	}
+
+	return lines
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func parseTOMLNode(data []byte) (*configNode, error) {
+	table := map[string]interface{}{}
+	if _, err := toml.NewDecoder(bytes.NewReader(data)).Decode(&table); err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("%d: %s", parseErr.Position.Line, parseErr.Message)
+		}
+		return nil, err
+	}
+	return convertTOMLNode(table, "", tomlKeyLines(data)), nil
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func convertTOMLNode(value interface{}, path string, lines map[string]int) *configNode {
+	node := &configNode{Line: lines[path], Value: value}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		node.Kind = "object"
+		node.Fields = map[string]*configNode{}
+		for key := range typed {
+			node.Keys = append(node.Keys, key)
+		}
+		sort.Strings(node.Keys)
+		for _, key := range node.Keys {
+			node.Fields[key] = convertTOMLNode(typed[key], joinConfigPath(path, key), lines)
+		}
+	case []map[string]interface{}:
+		node.Kind = "array"
+		for i, item := range typed {
+			node.Items = append(node.Items, convertTOMLNode(item, fmt.Sprintf("%s[%d]", path, i), lines))
+		}
+	case []interface{}:
+		node.Kind = "array"
+		for i, item := range typed {
+			child := convertTOMLNode(item, fmt.Sprintf("%s[%d]", path, i), lines)
+			if child.Line == 0 {
+				child.Line = node.Line
+			}
+			node.Items = append(node.Items, child)
+		}
+	case string:
+		node.Kind = "string"
+	case int64:
+		node.Kind = "integer"
+	case float64:
+		node.Kind = "number"
+	case bool:
+		node.Kind = "boolean"
+	case time.Time:
+		node.Kind = "datetime"
+	default:
+		node.Kind = "null"
+	}
+
+	return node
+}
+
+// ---------------------------------------------------------
+// Validation
+// ---------------------------------------------------------
+
+func joinConfigPath(parent string, key string) string {
+	if len(parent) == 0 {
+		return key
+	}
+	return parent + "." + key
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func schemaKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct:
+		return "object"
+	case reflect.Slice:
+		return "array"
+	case reflect.Int:
+		return "integer"
+	case reflect.Bool:
+		return "boolean"
+	}
+	return "string"
+}
+
+// checkNode compares a parsed value against the Go type json.Unmarshal will
+// put it in, recording the line of every path on the way.
+func (v *configValidator) checkNode(node *configNode, t reflect.Type, path string) {
+	v.lines[path] = node.Line
+
+	expected := schemaKind(t)
+	if node.Kind != expected {
+		if node.Kind == "null" {
+			return
+		}
+		if expected == "integer" && node.Kind == "string" {
+			if _, err := strconv.Atoi(fmt.Sprint(node.Value)); err == nil {
+				v.report(path, "expected an integer, found the string %q (remove the quotes)", node.Value)
+				return
+			}
+		}
+		v.report(path, "expected %s %s, found %s", article(expected), expected, node.Kind)
+		return
+	}
+
+	switch expected {
+	case "object":
+		for _, key := range node.Keys {
+			field, ok := configField(t, key)
+			if !ok {
+				v.lines[joinConfigPath(path, key)] = node.Fields[key].Line
+				v.report(joinConfigPath(path, key), "unknown key")
+				continue
+			}
+			v.checkNode(node.Fields[key], field.Type, joinConfigPath(path, jsonKey(field)))
+		}
+	case "array":
+		for i, item := range node.Items {
+			v.checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func article(kind string) string {
+	if strings.ContainsAny(kind[:1], "aeiou") {
+		return "an"
+	}
+	return "a"
+}
+
+// configField finds the struct field a key decodes into, case insensitive
+// like encoding/json.
+func configField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonKey(field)
+		if len(name) > 0 && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (v *configValidator) checkSpotifyID(path string, id string) {
+	if spotifyIDPattern.MatchString(id) {
+		return
+	}
+	if strings.Contains(id, "spotify") || strings.Contains(id, "/") {
+		v.report(path, "%q is not a Spotify ID, use only the 22 character ID at the end of the link or URI", id)
+		return
+	}
+	v.report(path, "%q is not a Spotify ID, expected 22 letters and digits", id)
+}
+
+// checkSchema checks that $schema is a URL or a path editors can load, a
+// relative path being next to the user data file.
+func (v *configValidator) checkSchema(userDataPath string, schema string) {
+	if len(schema) == 0 || v.reported("$schema") {
+		return
+	}
+
+	u, err := url.Parse(schema)
+	if err != nil {
+		v.report("$schema", "%q is not a URI or path: %s", schema, err)
+		return
+	}
+
+	path := schema
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+		return
+	case u.Scheme == "file":
+		path = u.Path
+	case len(u.Scheme) > 1:
+		// One letter schemes are Windows drives
+		v.report("$schema", "%q is not an http, https or file URI or a path", schema)
+		return
+	}
+
+	if !filepath.IsAbs(path) {
+		path = defaultPath(userDataPath, path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.note("$schema", "%s does not exist, save the output of config schema there", path)
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (v *configValidator) checkUser(u *UserData) {
+	value := reflect.ValueOf(u).Elem()
+
+	for _, key := range configRequiredUserKeys {
+		field, _ := configField(value.Type(), key)
+		if len(value.FieldByIndex(field.Index).String()) == 0 && !v.reported("user."+key) {
+			v.report("user."+key, "is required (or set %s)", userDataEnvName(key))
+		}
+	}
+
+	for _, key := range configPlaylistUserKeys {
+		field, _ := configField(value.Type(), key)
+		if id := value.FieldByIndex(field.Index).String(); len(id) > 0 {
+			v.checkSpotifyID("user."+key, id)
+		}
+	}
+
+	if len(u.LogsPath) > 0 {
+		if info, err := os.Stat(u.LogsPath); err != nil {
+			v.report("user.logs_path", "%s", err)
+		} else if !info.IsDir() {
+			v.report("user.logs_path", "%s is not a directory", u.LogsPath)
+		}
+	}
+
+	if len(u.LastRunPath) > 0 {
+		data, err := ioutil.ReadFile(u.LastRunPath)
+		if err != nil {
+			v.report("user.last_run_path", "%s", err)
+		} else {
+			dates := strings.Split(strings.TrimSpace(string(data)), ",")
+			if len(dates) != 2 {
+				v.report("user.last_run_path", "%s must contain two dates separated by a comma, year-month-day,year-month-day", u.LastRunPath)
+			}
+			for _, date := range dates {
+				if _, err := time.Parse(SQUE_DATE_FORMAT, date); err != nil {
+					v.report("user.last_run_path", "%s has a bad date %q, expected year-month-day", u.LastRunPath, date)
+				}
+			}
+		}
+	}
+
+	for i, name := range u.VersionPreference {
+		if !IsVersionName(name) {
+			v.report(fmt.Sprintf("user.version_preference[%d]", i), "%q is not one of original, feat, edit, remaster, remix, live, extended", name)
+		}
+	}
+
+	// Only the callback server needs plain http, -headless logins take any
+	if len(u.RedirectURI) > 0 {
+		if redirect, err := url.Parse(u.RedirectURI); err != nil {
+			v.report("user.redirect_uri", "could not parse %q: %s", u.RedirectURI, err)
+		} else if redirect.Scheme != "http" {
+			v.note("user.redirect_uri", "%q is not plain http, so logins need -headless", u.RedirectURI)
+		} else if _, _, err := CallbackAddress(u); err != nil {
+			v.report("user.redirect_uri", "%s", err)
+		}
+	}
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func (v *configValidator) checkPlaylists(playlists []PlaylistMetaData) {
+	seen := map[string]int{}
+
+	for i, playlist := range playlists {
+		path := fmt.Sprintf("playlists[%d]", i)
+
+		// Values of the wrong type were reported by checkNode
+		switch {
+		case v.reported(path + ".id"):
+		case len(playlist.ID) == 0:
+			v.report(path+".id", "is required")
+		default:
+			v.checkSpotifyID(path+".id", playlist.ID)
+
+			if first, ok := seen[playlist.ID]; ok {
+				v.report(path+".id", "duplicate of playlists[%d] (line %d)", first, v.lines[fmt.Sprintf("playlists[%d].id", first)])
+			} else {
+				seen[playlist.ID] = i
+			}
+		}
+
+		switch {
+		case v.reported(path + ".limit"):
+		case playlist.Limit < -1:
+			v.report(path+".limit", "must be -1 (no limit) or a number of tracks, found %d", playlist.Limit)
+		default:
+			if _, ok := v.lines[path+".limit"]; !ok {
+				v.report(path, "has no limit, so no tracks will ever be queued from it (use -1 for no limit)")
+			}
+		}
+	}
+}
+
+// ValidateConfigFile checks a user data file and returns every problem found
+// rather than stopping at the first.
+func ValidateConfigFile(path string) []ConfigProblem {
+	v := &configValidator{lines: map[string]int{}}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		v.report("", "%s", err)
+		return v.problems
+	}
+
+	format := DetectConfigFormat(path, data)
+
+	var root *configNode
+	switch format {
+	case ConfigFormat_YAML:
+		root, err = parseYAMLNode(data)
+	case ConfigFormat_TOML:
+		root, err = parseTOMLNode(data)
+	default:
+		root, err = parseJSONNode(data)
+	}
+	if err != nil {
+		v.report("", "could not parse as %s: %s", format, err)
+		return v.problems
+	}
+
+	v.checkNode(root, reflect.TypeOf(ConfigData{}), "")
+
+	// Decode as best we can, values of the wrong type were reported above
+	var c ConfigData
+	if jsonBytes, err := ConfigToJSON(data, format); err == nil {
+		json.Unmarshal(jsonBytes, &c)
+	}
+	// Environment problems have no line in the file
+	for _, err := range ApplyEnvOverrides(&c.User) {
+		v.problems = append(v.problems, ConfigProblem{Message: err.Error()})
+	}
+
+	v.checkSchema(path, c.Schema)
+	v.checkUser(&c.User)
+	v.checkPlaylists(c.Playlists)
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Line < v.problems[j].Line
+	})
+
+	return v.problems
+}
+
+// ---------------------------------------------------------
+// ---------------------------------------------------------
+func PrintConfigProblems(path string, problems []ConfigProblem) {
+	for _, problem := range problems {
+		message := problem.String()
+		if problem.Note {
+			message = "note: " + message
+		}
+
+		if problem.Line > 0 {
+			fmt.Printf("%s:%d: %s\n", path, problem.Line, message)
+		} else {
+			fmt.Printf("%s: %s\n", path, message)
+		}
+	}
+
+	if count := CountConfigErrors(problems); count == 0 {
+		fmt.Printf("%s is valid.\n", path)
+	} else {
+		fmt.Printf("Found %d problems in %s.\n", count, path)
+	}
+}